@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/borisshvonder/tally/tallylib"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// Roots are rescanned once a day unless configured otherwise
+const defaultDaemonInterval = 24 * time.Hour
+
+// Configuration file given with -config, see CONFIG FILE section of usage
+type configFile struct {
+	StatusFile string   // daemon only
+	Interval   duration // daemon only
+	MinDig     int
+	MaxDig     int
+	Config     tallylib.TallyConfig // applies to paths which are not roots
+	Roots      []configRoot
+}
+
+// Directory to update, either listed in configuration file or given on
+// command line
+type configRoot struct {
+	Name     string
+	Path     string
+	Interval duration // daemon only
+	MinDig   int
+	MaxDig   int
+	Config   tallylib.TallyConfig
+}
+
+// time.Duration written as "1h30m" in JSON
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var text string
+	var err = json.Unmarshal(data, &text)
+	if err != nil {
+		return err
+	}
+	var parsed time.Duration
+	parsed, err = time.ParseDuration(text)
+	*d = duration(parsed)
+	return err
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Settings in the file are applied over defaults (which come from command
+// line), top-level settings apply to all roots, settings of a root only to
+// that root
+func loadConfig(path string, defaults configRoot) (*configFile, error) {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		StatusFile string
+		Interval   duration
+		MinDig     int
+		MaxDig     int
+		Config     json.RawMessage
+		Roots      []json.RawMessage
+	}
+	file.MinDig = defaults.MinDig
+	file.MaxDig = defaults.MaxDig
+	err = json.Unmarshal(data, &file)
+	if err == nil && file.Config != nil {
+		defaults.Config = copyConfig(defaults.Config)
+		err = json.Unmarshal(file.Config, &defaults.Config)
+	}
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+
+	var ret = new(configFile)
+	ret.StatusFile = file.StatusFile
+	ret.Interval = file.Interval
+	if ret.Interval <= 0 {
+		ret.Interval = duration(defaultDaemonInterval)
+	}
+	ret.MinDig = file.MinDig
+	ret.MaxDig = file.MaxDig
+	ret.Config = defaults.Config
+	var names = make(map[string]bool)
+	for _, raw := range file.Roots {
+		var root = configRoot{Interval: ret.Interval, MinDig: ret.MinDig, MaxDig: ret.MaxDig, Config: copyConfig(ret.Config)}
+		err = json.Unmarshal(raw, &root)
+		if err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+		if root.Path == "" {
+			return nil, errors.New(path + ": root without Path")
+		}
+		if root.Interval <= 0 {
+			return nil, errors.New(path + ": " + root.Path + " has invalid Interval")
+		}
+		if root.Name != "" && names[root.Name] {
+			return nil, errors.New(path + ": duplicate root Name " + root.Name)
+		}
+		names[root.Name] = true
+		root.Path = filepath.Clean(root.Path)
+		ret.Roots = append(ret.Roots, root)
+	}
+	return ret, nil
+}
+
+// json.Unmarshal reuses backing arrays of slices it decodes into, so
+// config which is decoded over must not share them with any other
+func copyConfig(config tallylib.TallyConfig) tallylib.TallyConfig {
+	config.Exclude = append([]string(nil), config.Exclude...)
+	config.Include = append([]string(nil), config.Include...)
+	return config
+}
+
+// Returns roots to work on: every root of configuration file if there are
+// no arguments, otherwise roots named by arguments. Arguments which are
+// not root names are paths, they get top-level settings of the file
+func selectRoots(file *configFile, args []string) []configRoot {
+	if len(args) == 0 {
+		return file.Roots
+	}
+	var ret []configRoot
+	for _, arg := range args {
+		var found = false
+		for _, root := range file.Roots {
+			if root.Name != "" && root.Name == arg {
+				ret = append(ret, root)
+				found = true
+			}
+		}
+		if !found {
+			ret = append(ret, configRoot{
+				Path:     filepath.Clean(arg),
+				Interval: file.Interval,
+				MinDig:   file.MinDig,
+				MaxDig:   file.MaxDig,
+				Config:   file.Config,
+			})
+		}
+	}
+	return ret
+}
+
+// Flags which names differ from TallyConfig fields
+var flagFields = map[string]string{
+	"Workers":   "HashWorkers",
+	"Backups":   "CollectionBackups",
+	"HashCache": "HashCacheDir",
+}
+
+// Values given on command line take precedence over configuration file
+func overrideWithFlags(roots []configRoot, flags configRoot) {
+	var from = reflect.ValueOf(&flags.Config).Elem()
+	for i := range roots {
+		var to = reflect.ValueOf(&roots[i].Config).Elem()
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "MinDig":
+				roots[i].MinDig = flags.MinDig
+			case "MaxDig":
+				roots[i].MaxDig = flags.MaxDig
+			}
+			var name = f.Name
+			if field, ok := flagFields[name]; ok {
+				name = field
+			}
+			if field := from.FieldByName(name); field.IsValid() {
+				to.FieldByName(name).Set(field)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_loadConfig_will_not_share_patterns_between_roots(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "Test_loadConfig_will_not_share_patterns_between_roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	var path = filepath.Join(tmpdir, "tally.conf")
+	err = ioutil.WriteFile(path, []byte(`{
+		"Config": {"Exclude": ["a", "b"], "Include": ["*.mp3", "*.flac"]},
+		"Roots": [
+			{"Path": "/one", "Config": {"Exclude": ["c"], "Include": ["*.ogg"]}},
+			{"Path": "/two"}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var defaults configRoot
+	defaults.Config.Exclude = []string{"x", "y", "z"}
+	file, err := loadConfig(path, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, []string{"a", "b"}, file.Config.Exclude)
+	assertStrings(t, []string{"c"}, file.Roots[0].Config.Exclude)
+	assertStrings(t, []string{"*.ogg"}, file.Roots[0].Config.Include)
+	assertStrings(t, []string{"a", "b"}, file.Roots[1].Config.Exclude)
+	assertStrings(t, []string{"*.mp3", "*.flac"}, file.Roots[1].Config.Include)
+	assertStrings(t, []string{"x", "y", "z"}, defaults.Config.Exclude)
+}
+
+func assertStrings(t *testing.T, expected, actual []string) {
+	if !reflect.DeepEqual(expected, actual) {
+		t.Error("expected", expected, "got", actual)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/borisshvonder/tally/tallylib"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Contents of the status file
+type daemonStatus struct {
+	Started time.Time
+	Updated time.Time
+	Roots   []*rootStatus
+}
+
+// Counters describe the last finished run
+type rootStatus struct {
+	Path               string
+	Running            bool
+	LastRun            time.Time
+	LastDuration       duration
+	LastError          string
+	NextRun            time.Time
+	Runs               int
+	Failures           int
+	FilesHashed        int
+	BytesRead          int64
+	CollectionsWritten int
+	Warnings           []string
+}
+
+type daemon struct {
+	statusFile string
+	log        *log.Logger
+	lock       sync.Mutex // guards status
+	status     daemonStatus
+	write      sync.Mutex // serializes writing of status file
+	trees      *treeLocks
+}
+
+// Rescans every root on its schedule until ctx is done. Each root has its
+// own Tally and is updated by single goroutine, so runs of the same root
+// never overlap. Runs of roots which write the same collections (see
+// overlaps) wait for each other, other roots are updated concurrently
+func runDaemon(ctx context.Context, statusFile string, roots []configRoot) {
+	var d = new(daemon)
+	d.statusFile = statusFile
+	d.trees = newTreeLocks(roots)
+	d.log = log.New(os.Stdout, "DAEMON: ", log.Ldate|log.Ltime)
+	d.status.Started = time.Now()
+	for _, root := range roots {
+		d.status.Roots = append(d.status.Roots, &rootStatus{Path: root.Path})
+	}
+
+	var wait sync.WaitGroup
+	for i := range roots {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			d.runRoot(ctx, i, roots[i], d.status.Roots[i])
+		}(i)
+	}
+	wait.Wait()
+	d.writeStatus()
+}
+
+func (d *daemon) runRoot(ctx context.Context, index int, root configRoot, status *rootStatus) {
+	var tally = tallylib.NewTally()
+	tally.SetConfig(root.Config)
+	tally.SetLog(os.Stdout)
+
+	var next = time.Now()
+	for {
+		d.update(func() {
+			status.NextRun = next
+		})
+		var timer = time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !d.trees.acquire(ctx, index) {
+			return
+		}
+
+		var started = time.Now()
+		d.log.Println("Rescanning", root.Path)
+		d.update(func() {
+			status.Running = true
+		})
+		var _, err = tally.UpdateRecursiveContext(ctx, root.Path, root.MinDig, root.MaxDig)
+		d.trees.release(index)
+		var result = tally.GetResult()
+		var elapsed = result.Elapsed
+		if err != nil {
+			d.log.Println("Failed", root.Path, "after", elapsed, err)
+		} else {
+			d.log.Println("Finished", root.Path, "in", elapsed)
+		}
+
+		d.update(func() {
+			status.Running = false
+			status.LastRun = started
+			status.LastDuration = duration(elapsed)
+			status.Runs++
+			status.LastError = ""
+			if err != nil {
+				status.LastError = err.Error()
+			}
+			// Being interrupted on shutdown is not a failure
+			if err != nil && err != context.Canceled {
+				status.Failures++
+			}
+			status.FilesHashed = result.FilesHashed
+			status.BytesRead = result.BytesRead
+			status.CollectionsWritten = len(result.Collections)
+			status.Warnings = nil
+			for _, warning := range result.Warnings {
+				status.Warnings = append(status.Warnings, warning.Error())
+			}
+		})
+
+		// Schedule is kept regardless of how long runs take, overdue run
+		// starts right away
+		next = next.Add(time.Duration(root.Interval))
+		if next.Before(time.Now()) {
+			next = time.Now()
+		}
+	}
+}
+
+// Modifies status under lock and writes it out
+func (d *daemon) update(modify func()) {
+	d.lock.Lock()
+	modify()
+	d.lock.Unlock()
+	d.writeStatus()
+}
+
+// Status file is replaced atomically, so readers never see it half-written
+func (d *daemon) writeStatus() {
+	if d.statusFile == "" {
+		return
+	}
+	d.write.Lock()
+	defer d.write.Unlock()
+	d.lock.Lock()
+	d.status.Updated = time.Now()
+	var data, err = json.MarshalIndent(&d.status, "", "\t")
+	d.lock.Unlock()
+
+	if err == nil {
+		err = tallylib.WriteFileAtomic(d.statusFile, 0644, func(out io.Writer) error {
+			var _, writeErr = out.Write(data)
+			return writeErr
+		})
+	}
+	if err != nil {
+		d.log.Println("Cannot write status file", d.statusFile, err)
+	}
+}
+
+// Serializes runs of roots which overlap
+type treeLocks struct {
+	roots   []configRoot
+	lock    sync.Mutex
+	running map[int]bool  // by root index
+	changed chan struct{} // closed when a run finishes
+}
+
+func newTreeLocks(roots []configRoot) *treeLocks {
+	var ret = new(treeLocks)
+	ret.roots = roots
+	ret.running = make(map[int]bool)
+	ret.changed = make(chan struct{})
+	return ret
+}
+
+// Waits until no overlapping root is running and marks root as running.
+// Returns false if ctx is done first
+func (locks *treeLocks) acquire(ctx context.Context, index int) bool {
+	for {
+		locks.lock.Lock()
+		var busy = false
+		for other := range locks.running {
+			busy = busy || overlaps(locks.roots[index], locks.roots[other])
+		}
+		if !busy {
+			locks.running[index] = true
+			locks.lock.Unlock()
+			return true
+		}
+		var changed = locks.changed
+		locks.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+func (locks *treeLocks) release(index int) {
+	locks.lock.Lock()
+	delete(locks.running, index)
+	close(locks.changed)
+	locks.changed = make(chan struct{})
+	locks.lock.Unlock()
+}
+
+// Returns true if updates of both roots may write the same collections:
+// one root is inside the other, or they have a common parent (other than
+// the filesystem root, which is never updated) and parents are updated
+// because of UpdateParents
+func overlaps(a, b configRoot) bool {
+	var pathA, pathB = absPath(a.Path), absPath(b.Path)
+	if isWithin(pathA, pathB) || isWithin(pathB, pathA) {
+		return true
+	}
+	if !a.Config.UpdateParents && !b.Config.UpdateParents {
+		return false
+	}
+	for parent := filepath.Dir(pathA); parent != filepath.Dir(parent); parent = filepath.Dir(parent) {
+		if isWithin(pathB, parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if path is directory itself or is below it
+func isWithin(path, directory string) bool {
+	var rel, err = filepath.Rel(directory, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func absPath(path string) string {
+	var ret, err = filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return ret
+}
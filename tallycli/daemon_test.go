@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_overlaps(t *testing.T) {
+	var root = func(path string, updateParents bool) configRoot {
+		var ret = configRoot{Path: path}
+		ret.Config.UpdateParents = updateParents
+		return ret
+	}
+	var cases = []struct {
+		a, b     configRoot
+		expected bool
+	}{
+		{root("/my/music", false), root("/my/music", false), true},
+		{root("/my", false), root("/my/music", false), true},
+		{root("/my/music", false), root("/my/musicals", false), false},
+		{root("/my/music", false), root("/my/video", true), true},
+		{root("/my/music", false), root("/my/video", false), false},
+		{root("/data/music", true), root("/my/video", true), false},
+	}
+	for _, c := range cases {
+		if overlaps(c.a, c.b) != c.expected || overlaps(c.b, c.a) != c.expected {
+			t.Error(c.a.Path, c.b.Path, "overlap expected", c.expected)
+		}
+	}
+}
+
+func Test_treeLocks_will_serialize_overlapping_roots(t *testing.T) {
+	var locks = newTreeLocks([]configRoot{{Path: "/my"}, {Path: "/my/music"}, {Path: "/data"}})
+	var ctx = context.Background()
+	locks.acquire(ctx, 0)
+	if !locks.acquire(ctx, 2) {
+		t.Error("unrelated root must not wait")
+	}
+
+	var acquired = make(chan bool)
+	go func() {
+		acquired <- locks.acquire(ctx, 1)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("nested root must wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	locks.release(2)
+	locks.release(0)
+	if !<-acquired {
+		t.Error("nested root must run once the other one is done")
+	}
+
+	var cancelled, cancel = context.WithCancel(ctx)
+	cancel()
+	if locks.acquire(cancelled, 0) {
+		t.Error("acquire must give up once ctx is done")
+	}
+}
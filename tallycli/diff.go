@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/borisshvonder/tally/tallylib"
+	"os"
+)
+
+// Prints differences between old and new collection files, returns false
+// if there were any
+func diff(args []string, asJson bool) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("diff: expected old and new collection files")
+	}
+	var old, err = loadCollection(args[0])
+	if err != nil {
+		return false, err
+	}
+	coll, err := loadCollection(args[1])
+	if err != nil {
+		return false, err
+	}
+	var diff = tallylib.DiffCollections(old, coll)
+
+	if asJson {
+		var data, err = json.MarshalIndent(&diff, "", "\t")
+		if err != nil {
+			return false, err
+		}
+		os.Stdout.Write(append(data, '\n'))
+		return diff.Empty(), nil
+	}
+	for _, entry := range diff.Added {
+		fmt.Println("+", entry.Name)
+	}
+	for _, entry := range diff.Removed {
+		fmt.Println("-", entry.Name)
+	}
+	for _, entry := range diff.Renamed {
+		fmt.Println(">", entry.OldName, "->", entry.Name)
+	}
+	for _, entry := range diff.Changed {
+		fmt.Println("*", entry.Name)
+	}
+	fmt.Printf("%d added, %d removed, %d renamed, %d changed\n",
+		len(diff.Added), len(diff.Removed), len(diff.Renamed), len(diff.Changed))
+	return diff.Empty(), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/borisshvonder/tally/tallylib"
+	"io"
+	"os"
+)
+
+// Writes listing of collection file or collection tree of a folder to
+// output, stdout if output is empty
+func export(ctx context.Context, tally tallylib.Tally, root configRoot, format tallylib.ExportFormat, output string) error {
+	var entries []tallylib.ExportEntry
+	var directory string
+	var stat, err = os.Stat(root.Path)
+	if err == nil && !stat.IsDir() {
+		var coll tallylib.RSCollection
+		coll, err = loadCollection(root.Path)
+		if err == nil {
+			entries = tallylib.ExportEntries(coll)
+		}
+	} else {
+		tally.SetConfig(root.Config)
+		directory = root.Path
+		entries, err = tally.ExportContext(ctx, directory)
+	}
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		return tallylib.WriteExport(os.Stdout, entries, format, directory)
+	}
+	err = tallylib.WriteFileAtomic(output, 0666, func(out io.Writer) error {
+		return tallylib.WriteExport(out, entries, format, directory)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d files\n", output, len(entries))
+	return nil
+}
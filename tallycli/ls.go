@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/borisshvonder/tally/tallylib"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var treeOrders = map[string]tallylib.TreeOrder{
+	"name": tallylib.OrderByName,
+	"size": tallylib.OrderBySize,
+	"time": tallylib.OrderByTime,
+}
+
+// Prints directory tree of every collection file in args
+func ls(args []string, order string, long, asJson bool) error {
+	if len(args) == 0 {
+		return errors.New("ls: no collection files given")
+	}
+	var treeOrder, found = treeOrders[order]
+	if !found {
+		return fmt.Errorf("ls: unknown -Sort %q, expected name, size or time", order)
+	}
+	for i, arg := range args {
+		var coll, err = loadCollection(arg)
+		if err != nil {
+			return err
+		}
+		var root = tallylib.CollectionTree(coll)
+		root.Sort(treeOrder)
+
+		if asJson {
+			var data, err = json.MarshalIndent(jsonTree(arg, root), "", "\t")
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(append(data, '\n'))
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		var out *tabwriter.Writer
+		if long {
+			out = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+			printLongDirectory(out, arg, root, 0)
+		} else {
+			out = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			printDirectory(out, arg, root, 0)
+		}
+		out.Flush()
+	}
+	return nil
+}
+
+func printDirectory(out *tabwriter.Writer, name string, dir *tallylib.CollectionDirectory, depth int) {
+	var indent = strings.Repeat("  ", depth)
+	fmt.Fprintf(out, "%s%s\t%s\t%d files\n", indent, name, tallylib.HumanSize(dir.TotalSize), dir.FileCount)
+	for _, sub := range dir.Directories {
+		printDirectory(out, sub.Name+"/", sub, depth+1)
+	}
+	for _, file := range dir.Files {
+		fmt.Fprintf(out, "%s  %s\t%s\n", indent, path.Base(file.Name()), tallylib.HumanSize(file.Size()))
+	}
+}
+
+func printLongDirectory(out *tabwriter.Writer, name string, dir *tallylib.CollectionDirectory, depth int) {
+	var indent = strings.Repeat("  ", depth)
+	fmt.Fprintf(out, "%d files\t%d\t%s\t\t%s%s\n",
+		dir.FileCount, dir.TotalSize, formatTime(dir.NewestModTime), indent, name)
+	for _, sub := range dir.Directories {
+		printLongDirectory(out, sub.Name+"/", sub, depth+1)
+	}
+	for _, file := range dir.Files {
+		fmt.Fprintf(out, "\t%d\t%s\t%s\t%s  %s\n",
+			file.Size(), formatTime(file.Timestamp()), file.Sha1(), indent, path.Base(file.Name()))
+	}
+}
+
+func formatTime(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return "-"
+	}
+	return timestamp.Local().Format("2006-01-02 15:04")
+}
+
+type jsonDirectory struct {
+	Name          string
+	FileCount     int
+	TotalSize     int64
+	NewestModTime *time.Time `json:",omitempty"`
+	Directories   []*jsonDirectory
+	Files         []jsonFile
+}
+
+type jsonFile struct {
+	Name    string
+	Sha1    string
+	Size    int64
+	Updated *time.Time `json:",omitempty"`
+}
+
+func jsonTree(name string, dir *tallylib.CollectionDirectory) *jsonDirectory {
+	var ret = &jsonDirectory{
+		Name:          name,
+		FileCount:     dir.FileCount,
+		TotalSize:     dir.TotalSize,
+		NewestModTime: optionalTime(dir.NewestModTime),
+		Directories:   []*jsonDirectory{},
+		Files:         []jsonFile{},
+	}
+	for _, sub := range dir.Directories {
+		ret.Directories = append(ret.Directories, jsonTree(sub.Name, sub))
+	}
+	for _, file := range dir.Files {
+		ret.Files = append(ret.Files, jsonFile{
+			Name:    path.Base(file.Name()),
+			Sha1:    file.Sha1(),
+			Size:    file.Size(),
+			Updated: optionalTime(file.Timestamp()),
+		})
+	}
+	return ret
+}
+
+func optionalTime(timestamp time.Time) *time.Time {
+	if timestamp.IsZero() {
+		return nil
+	}
+	return &timestamp
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/borisshvonder/tally/tallylib"
+	"io"
+	"os"
+	"strings"
+)
+
+var conflictPolicies = []tallylib.MergeConflictPolicy{
+	tallylib.MergeFail,
+	tallylib.MergeKeepFirst,
+	tallylib.MergeKeepNewest,
+	tallylib.MergeRename,
+}
+
+func parseConflictPolicy(name string) (tallylib.MergeConflictPolicy, error) {
+	var names []string
+	for _, policy := range conflictPolicies {
+		if policy.String() == name {
+			return policy, nil
+		}
+		names = append(names, policy.String())
+	}
+	return tallylib.MergeFail, fmt.Errorf("merge: unknown -Conflicts %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// Merges input collection files into output. Output is written only after
+// all inputs are loaded, so it may be one of them
+func merge(output string, inputs []string, options tallylib.MergeOptions, strict bool) error {
+	if output == "" {
+		return errors.New("merge: output file not given, use -o")
+	}
+	if len(inputs) == 0 {
+		return errors.New("merge: no input collections given")
+	}
+	var colls []tallylib.RSCollection
+	for _, input := range inputs {
+		var coll, err = loadCollection(input)
+		if err != nil {
+			return err
+		}
+		colls = append(colls, coll)
+	}
+	var merged, err = tallylib.MergeCollections(colls, options)
+	if err != nil {
+		return err
+	}
+
+	err = tallylib.WriteFileAtomic(output, 0666, func(out io.Writer) error {
+		if strict {
+			return tallylib.StoreStrict(merged, out)
+		}
+		return merged.StoreTo(out)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d files from %d collections\n", output, merged.Size(), len(colls))
+	return nil
+}
+
+func loadCollection(path string) (tallylib.RSCollection, error) {
+	var in, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var coll = tallylib.NewCollection()
+	err = coll.LoadFrom(in)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return coll, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"github.com/borisshvonder/tally/tallylib"
+	"io"
+	"sync"
+	"time"
+)
+
+// How often progress line is redrawn
+const progressRefresh = 200 * time.Millisecond
+
+// Renders single live progress line with throughput and ETA
+type progressLine struct {
+	lock       sync.Mutex
+	out        io.Writer
+	started    time.Time
+	drawn      time.Time
+	totalFiles int
+	totalBytes int64
+	doneFiles  int
+	doneBytes  int64            // bytes of files completely checked
+	bytesRead  int64            // bytes actually read by finished hashes
+	inFlight   map[string]int64 // bytes read so far by running hashes
+	current    string
+}
+
+func newProgressLine(out io.Writer) *progressLine {
+	var ret = new(progressLine)
+	ret.out = out
+	ret.started = time.Now()
+	ret.inFlight = make(map[string]int64)
+	return ret
+}
+
+func (p *progressLine) ScanFinished(files int, bytes int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.totalFiles += files
+	p.totalBytes += bytes
+	p.draw(true)
+}
+
+func (p *progressLine) DirectoryEntered(directory string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.current = directory
+	p.draw(false)
+}
+
+func (p *progressLine) HashStarted(fullpath string, size int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inFlight[fullpath] = 0
+	p.current = fullpath
+	p.draw(false)
+}
+
+func (p *progressLine) HashProgress(fullpath string, bytesRead int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inFlight[fullpath] = bytesRead
+	p.draw(false)
+}
+
+func (p *progressLine) HashFinished(fullpath string, size, bytesRead int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.inFlight, fullpath)
+	p.doneFiles++
+	p.doneBytes += size
+	p.bytesRead += bytesRead
+	p.draw(false)
+}
+
+func (p *progressLine) CollectionWritten(collectionFile string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.current = collectionFile
+	p.draw(true)
+}
+
+func (p *progressLine) ParentUpdated(directory string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.current = directory
+	p.draw(true)
+}
+
+// Finishes the line so that following output starts on a new one
+func (p *progressLine) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.draw(true)
+	fmt.Fprintln(p.out)
+}
+
+func (p *progressLine) draw(force bool) {
+	var now = time.Now()
+	if !force && now.Sub(p.drawn) < progressRefresh {
+		return
+	}
+	p.drawn = now
+
+	var done = p.doneBytes
+	var read = p.bytesRead
+	for _, n := range p.inFlight {
+		done += n
+		read += n
+	}
+	// Pre-scan does not count collection files written during update
+	var totalFiles, totalBytes = p.totalFiles, p.totalBytes
+	if p.doneFiles > totalFiles {
+		totalFiles = p.doneFiles
+	}
+	if done > totalBytes {
+		totalBytes = done
+	}
+	// Unchanged files are checked without reading them, so ETA is based
+	// on how fast files get checked rather than on read throughput
+	var elapsed = now.Sub(p.started).Seconds()
+	var throughput, rate float64
+	if elapsed > 0 {
+		throughput = float64(read) / elapsed
+		rate = float64(done) / elapsed
+	}
+	var eta = "?"
+	if totalBytes == done {
+		eta = "0s"
+	} else if rate > 0 {
+		eta = (time.Duration(float64(totalBytes-done)/rate) * time.Second).String()
+	}
+
+	fmt.Fprintf(p.out, "\r\033[K%d/%d files, %s/%s, %s/s, ETA %s %s",
+		p.doneFiles, totalFiles,
+		tallylib.HumanSize(done), tallylib.HumanSize(totalBytes),
+		tallylib.HumanSize(int64(throughput)), eta, shorten(p.current, 40))
+}
+
+// Keeps the tail of too long strings
+func shorten(s string, max int) string {
+	var runes = []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return "..." + string(runes[len(runes)-max+3:])
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/borisshvonder/tally/tallylib"
 	"flag"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
 )
 
 var version string // set by linker
@@ -15,7 +19,16 @@ func main() {
 	flag.Usage = func() {
 		fmt.Println("This program is designed to overcome RetroShare default search limitations. It generates <folder>.rscollection file for each folder encountered, forming so-called 'collection tree', that is a tree of .rscollection files referencing each other. These <folder>.rscollection files serve as RetroShare 'folders' that can be found using RetroShare search without revealing folder structure to any peers directly.")
 		var me = os.Args[0]
-		fmt.Fprintf(flag.CommandLine.Output(), "USAGE: %s [options] [folder1, folder2, ...]\n", me)
+		fmt.Fprintf(flag.CommandLine.Output(), "USAGE: %s [command] [options] [folder1 or root name, ...]\n", me)
+		fmt.Fprintf(flag.CommandLine.Output(), "COMMANDS:\n"+
+			"  update (default)\n\tcreate or update collection tree\n"+
+			"  verify\n\trehash all files referenced by collections and report mismatches, changes nothing\n"+
+			"  watch\n\tupdate collection tree, then keep it up to date as files change (Linux only)\n"+
+			"  daemon\n\tperiodically rescan roots, see DAEMON\n"+
+			"  merge\n\tmerge collection files given instead of folders into one, see MERGE\n"+
+			"  diff\n\tcompare old and new collection files given instead of folders, see DIFF\n"+
+			"  ls\n\tshow what is inside collection files given instead of folders, see LS\n"+
+			"  export\n\twrite collection file or collection tree of a folder as sha1sum, SFV, CSV or JSON listing, see EXPORT\n")
 		flag.PrintDefaults()
 		fmt.Printf(`EXAMPLES
 	tally -IgnoreWarnings /my/audiobooks
@@ -31,6 +44,21 @@ func main() {
 		Quietly update just 
 		/my/audiobooks/Heller/Something-Happened.rscollection
 
+	tally -LogVerbosity 0 -DryRun -MaxDig=1 /my/audiobooks
+		Print which .rscollection files would be created or rewritten
+		without touching any of them. Entries are prefixed with
+		'+' (added), '-' (removed) or '*' (sha1 changed)
+
+	tally verify -LogVerbosity 1 /my/audiobooks
+		Rehash every file referenced by collections in /my/audiobooks
+		and list corrupted, missing and unreadable ones. Exits with 
+		code 1 if any were found
+
+	tally watch -IgnoreWarnings -HashCache ~/.cache/tally /my/audiobooks
+		Update collection tree of /my/audiobooks and keep updating
+		collections of changed folders (and their parents) until
+		interrupted. Settle time is set with -WatchDebounce
+
 COLLECTION EXPRESSIONS
 
 	By default, tally assigns collection file names same as respective
@@ -43,8 +71,8 @@ COLLECTION EXPRESSIONS
 	to last path component (.Path 0) in directory pathname, ex "dir" 
 	for "/path/to/dir" plus ".rscollection" string
 
-	Currently templating only allows you selecting parent directories and
-	it is easier to explain usng following example:
+	Templates select parent directories with .Path and it is easier to
+	explain usng following example:
 
 	* assuming we have a directory /music/Depeche Mode/Violator_1993 ...
 
@@ -60,6 +88,52 @@ COLLECTION EXPRESSIONS
 
 	* -CollectionPathnameExpression="/collections/{{.Path 0}}.rscollection"
 
+	Besides .Path, templates can use:
+
+	  .Depth                    depth of directory below the directory
+	                            given on command line, 0 for that one
+	  .RelativePath             directory path relative to the one given
+	                            on command line, "" for that one
+	  .RootName                 name of the directory given on command line
+	  .FileCount                number of files in directory and its
+	                            subdirectories, excluding collections
+	  .TotalSize                total size of those files in bytes
+	  .NewestModTime            latest modification time of those files,
+	                            format it with .NewestModTime.Format "2006"
+
+	* ...Expression="{{.Path 0}} ({{.FileCount}} files, {{humanSize .TotalSize}}).rscollection"
+	  will produce value "Violator_1993 (12 files, 540MB).rscollection"
+
+	Such names change along with folder contents. When folder is
+	updated, its collection written under the previous name is removed
+	(the parent's collection then refers to the new one), so keep
+	-UpdateParents on when updating single folders.
+
+	Path components can be transformed with functions, either called
+	directly or in a pipeline, where the value goes last:
+
+	  lower S, upper S          change case
+	  title S                   upper-case first letter of every word
+	  replace OLD NEW S         replace every OLD with NEW
+	  regexReplace RE REPL S    replace matches of regular expression,
+	                            REPL can refer to groups as $1
+	  trim S, trim CHARS S      remove leading and trailing spaces or
+	                            characters from CHARS
+	  truncate N S              keep at most N first characters
+	  join SEP S...             join non-empty values with SEP
+	  default DEF S             DEF if S is empty
+	  slug S                    lower-case letters and digits separated
+	                            by single dashes
+	  humanSize N               format byte count like "1.5KB" or "540MB"
+
+	* ...Expression="{{.Path 0 | regexReplace \"_[0-9]{4}$\" \"\" | lower}}.rscollection"
+	  will produce value "violator.rscollection"
+
+	* ...Expression="{{join \"-\" (.Path -1) (.Path 0) | slug}}.rscollection"
+	  will produce value "depeche-mode-violator-1993.rscollection"
+
+	The same functions are available in -CollectionRootPathExpression
+
 COLLECTION ROOT PATH EXPRESSIONS
 	Default behavior is simply put files found into collection at the
 	top, for path
@@ -132,14 +206,214 @@ DIG DEPTH
 
 	The corner case is -MaxDig=0, in this case tally will generate just
 	one large collection containing all files in a given folder.
+
+	Collections that large are hard to browse in RetroShare, so they
+	can be split with -MaxCollectionEntries and -MaxCollectionSize:
+
+	tally -MaxDig=0 -MaxCollectionEntries=1000 /music
+	will create /music.part1.rscollection, /music.part2.rscollection
+	and so on instead of /music.rscollection. Files stay in their part
+	on later runs as long as it has room, so unchanged parts are not
+	rewritten. Parts left empty are removed without renumbering the
+	rest, new files fill such gaps first.
 	
 
+PATTERNS
+	-Exclude and -Include accept gitignore-style patterns:
+
+	* -Exclude=.DS_Store -Exclude=Thumbs.db -Exclude='*.part'
+	  leave these files out wherever they are found
+
+	* -Exclude=.git/
+	  trailing slash matches only directories, which are not descended
+	  into at all
+
+	* -Exclude=/incoming -Exclude='Artist/**/cover.jpg'
+	  patterns with slashes are relative to the folder given on the 
+	  command line, ** matches any number of directories
+
+	* -Exclude='*.part' -Exclude='!keep.part'
+	  ! re-includes files excluded by earlier patterns, the last 
+	  matching pattern wins
+
+	* -Include='*.mp3' -Include='*.flac'
+	  only put matching files into collections. Collections of 
+	  subfolders are always put into parent collections
+
+	Files which are already in collections and become excluded are
+	removed from them.
+
+	Any folder may contain a .tallyignore file with more -Exclude 
+	patterns, one per line (lines starting with # are comments). They 
+	apply to that folder and its subfolders, patterns with slashes are
+	relative to the folder containing .tallyignore. Deeper .tallyignore
+	files override upper ones, which override -Exclude flags, so 
+	'!pattern' in .tallyignore can bring back globally excluded files.
+
+PER-FOLDER SETTINGS
+	Any folder may contain a .tally.conf file overriding settings for
+	that folder and its subfolders, so a single run can treat parts of
+	a share differently. It is JSON with any of these settings:
+
+	{
+		"CollectionPathnameExpression": "{{.Path -1}}-{{.Path 0}}.rscollection",
+		"CollectionRootPathExpression": "{{.Path 0}}",
+		"MinDig": 1,
+		"MaxDig": 2,
+		"Exclude": ["*.m3u"]
+	}
+
+	Deeper .tally.conf files override upper ones, which override the
+	command line. Files of all parent folders are read, even of ones
+	above the folder tally is run on, so "tally -UpdateParents
+	/my/audiobooks/Heller/Catch-22" names collections the same way
+	"tally /my/audiobooks" does. -SettingsBoundary /my stops the search
+	at /my, .tally.conf and .tallyignore files above it are ignored. -MinDig and -MaxDig of .tally.conf count levels from
+	the folder it is in, if only one is given the other takes its
+	default. Folders below -MaxDig are put into their parent's
+	collection as a whole and their .tally.conf dig limits and
+	expressions are not used. Exclude patterns work like .tallyignore
+	ones of the same folder, which take precedence over them.
+
+CONFIG FILE
+	Instead of long command lines, settings and folders to update can be
+	kept in a JSON file given with -config:
+
+	{
+		"MaxDig": 2,
+		"Config": {"IgnoreWarnings": true, "HashWorkers": 4},
+		"Roots": [
+			{"Name": "books", "Path": "/my/audiobooks"},
+			{"Name": "music", "Path": "/my/music", "MinDig": 1,
+			 "Config": {
+				"Exclude": ["*.part"],
+				"CollectionPathnameExpression": "{{.Path -1}}-{{.Path 0}}.rscollection"
+			 }}
+		]
+	}
+
+	"Config" accepts the same settings as command line options (with
+	library names, e.g. HashWorkers for -Workers, CollectionBackups for
+	-Backups, HashCacheDir for -HashCache). Top-level settings apply to
+	all roots, settings of a root only to that root. Options given on
+	the command line override both.
+
+	tally -config tally.conf
+		Update every root listed in tally.conf
+	tally verify -config tally.conf -LogVerbosity 1 music
+		Verify just the root named "music"
+	tally -config tally.conf -DryRun /my/video
+		Folders which are not root names get top-level settings
+
+DAEMON
+	tally daemon -config tally.conf runs until interrupted and rescans
+	every root on its own schedule. The first rescan starts right away.
+	Roots are rescanned concurrently, but runs of the same root never
+	overlap. Neither do runs of roots writing the same collections: same
+	or nested folders, or, with -UpdateParents, folders sharing a parent
+	(/my/audiobooks and /my/music below share /my). Schedule and status file are set in the configuration file:
+
+	{
+		"StatusFile": "/var/lib/tally/status.json",
+		"Interval": "24h",
+		"Roots": [
+			{"Path": "/my/audiobooks", "Interval": "6h"},
+			{"Path": "/my/music"}
+		]
+	}
+
+	Status file is rewritten whenever a run starts or finishes and lists,
+	for every root, last run time, duration and error, time of the next
+	run, files hashed, bytes read, collections written and warnings
+	ignored (see -IgnoreWarnings) by the last run.
+
+MERGE
+	tally merge -o all.rscollection a.rscollection b.rscollection
+		Write every file of a.rscollection and b.rscollection into
+		all.rscollection. Files with the same name and sha1 are
+		listed once
+
+	tally merge -o all.rscollection -Prefix Books -Prefix Music \
+			books.rscollection music.rscollection
+
+		Put files of each input under its -Prefix, in the order
+		inputs are given: "Books/file.txt", "Music/file.mp3"
+
+	Files with the same name but different sha1 are handled as set by
+	-Conflicts:
+
+	  fail     stop without writing anything (default)
+	  first    keep the file of the input given first
+	  newest   keep the file with the latest "updated" timestamp
+	  rename   keep both, later ones as "name (2).ext", "name (3).ext"
+
+	-StrictRetroShareFormat applies to the merged collection as well.
+
+DIFF
+	tally diff old.rscollection new.rscollection
+		List files of new.rscollection which are not in 
+		old.rscollection ('+'), files which are gone ('-'), files
+		with the same sha1 under a new name ('>') and files which
+		sha1 has changed ('*'). Exits with code 1 if collections
+		differ
+
+	tally -Backups 1 /my/audiobooks/Heller
+	tally diff /my/audiobooks/Heller.rscollection.bak \
+			/my/audiobooks/Heller.rscollection
+		Review what the update has changed
+
+	tally diff -Json old.rscollection new.rscollection
+		Print the same as JSON object with Added, Removed, Renamed
+		and Changed lists, e.g. to publish a changelog
+
+LS
+	tally ls /my/audiobooks/Heller.rscollection
+		Show folders and files of the collection as a tree, with
+		number of files and total size of every folder
+
+	tally ls -long -Sort size /my/audiobooks/Heller.rscollection
+		Also show exact sizes, "updated" timestamps (newest one for
+		folders) and sha1, biggest first. -Sort time puts newest
+		first, default is -Sort name
+
+	tally ls -json /my/audiobooks/Heller.rscollection
+		Print the same tree as JSON
+
+EXPORT
+	tally export -o Heller.sha1 /my/audiobooks/Heller.rscollection
+		Write sha1 of every file in the collection in the format
+		of sha1sum. Peers who downloaded the collection can check
+		files with 'sha1sum -c Heller.sha1'
+
+	tally export -format=sfv -o audiobooks.sfv /my/audiobooks
+		List files of every collection in the tree of a folder
+		(or a root of -config), with paths relative to it
+
+	-Format is one of:
+
+	  sha1sum  "<sha1>  <path>" lines, the default
+	  sfv      "<path> <CRC32>" lines. Collections have no CRC32, so
+	           this is only possible for folders and reads every file
+	  csv      Path,Sha1,Size,Updated with a header line
+	  json     array of objects with the same fields
+
+	Without -o the listing is written to standard output and log
+	messages go to standard error.
+
 BUGS
 	In order to efficiently detect if file needs it's sha1 recalculated, 
 	this tool stores file modification time in .rscollection file as 
 	non-standard (unsupported by RetroShare) attribute "updated". 
 	So far, RetroShare does not seem to care, but, in future, it may stop
 	handling such files.	
+	With -HashCache, sha1 of files is also remembered in a cache outside
+	of the tree, so files are not rehashed even when .rscollection has
+	no "updated" attributes (for example, written by other software).
+	-StrictRetroShareFormat writes collections without "updated", in
+	the layout RetroShare sources describe (not yet checked against a
+	collection exported by RetroShare itself). Existing collections are
+	rewritten on the next run. Combine it with -HashCache, otherwise
+	every file is rehashed on each run.
 `)
 		fmt.Printf("OS: %s\nArchitecture: %s\n", runtime.GOOS, runtime.GOARCH)
 		fmt.Println("Version:", version)
@@ -154,6 +428,47 @@ BUGS
 	flag.BoolVar(&config.UpdateParents, "UpdateParents", true, "when updating a directory, also update parent directories")
 	flag.BoolVar(&config.ForceUpdate, "ForceUpdate", false, "rehash files regardless of their sizes and timestamps")
 	flag.IntVar(&config.LogVerbosity, "LogVerbosity", 3, "log level [0-4], default:3")
+	flag.BoolVar(&config.DryRun, "DryRun", false, "do not write any .rscollection files, just print what would be changed")
+	flag.IntVar(&config.HashWorkers, "Workers", 1, "number of files to hash concurrently, consider increasing on SSDs")
+	flag.IntVar(&config.CollectionBackups, "Backups", 0, "keep this many previous versions of each .rscollection as <name>.bak, <name>.bak.2, ...")
+	flag.IntVar(&config.MaxCollectionEntries, "MaxCollectionEntries", 0, "split collections with more entries into <name>.part1.rscollection, <name>.part2.rscollection, ... 0 means no limit")
+	flag.Int64Var(&config.MaxCollectionSize, "MaxCollectionSize", 0, "split collections of more bytes in total into parts, see -MaxCollectionEntries")
+	flag.StringVar(&config.HashCacheDir, "HashCache", "", "directory to keep hash cache in (e.g. ~/.cache/tally), unchanged files found there are not rehashed")
+	flag.BoolVar(&config.StrictRetroShareFormat, "StrictRetroShareFormat", false, "write collections without non-standard attributes, see BUGS")
+	flag.DurationVar(&config.WatchDebounce, "WatchDebounce", config.WatchDebounce, "watch: wait this long for changes to calm down before updating collections")
+	flag.BoolVar(&config.ContinueOnError, "ContinueOnError", false, "skip folders that fail to update, list all failures at the end and exit with non-zero code")
+	flag.BoolVar(&config.FollowSymlinks, "FollowSymlinks", false, "follow symbolic links to files and directories, link cycles are skipped")
+	flag.BoolVar(&config.SymlinksWithinRoot, "SymlinksWithinRoot", false, "with -FollowSymlinks, only follow links pointing inside the directory being updated")
+	flag.StringVar(&config.SettingsBoundary, "SettingsBoundary", "", "do not read .tally.conf and .tallyignore files above this folder. See PER-FOLDER SETTINGS")
+
+	flag.Var((*stringList)(&config.Exclude), "Exclude", "gitignore-style pattern of files and directories to leave out, can be repeated. See PATTERNS")
+	flag.Var((*stringList)(&config.Include), "Include", "gitignore-style pattern of files to put into collections, can be repeated. See PATTERNS")
+
+	var Progress bool
+	flag.BoolVar(&Progress, "Progress", false, "show live progress line with ETA on stderr, best used with -LogVerbosity=1")
+
+	var ConfigFile string
+	flag.StringVar(&ConfigFile, "config", "", "JSON configuration file with settings and named roots, see CONFIG FILE")
+
+	var Output string
+	flag.StringVar(&Output, "o", "", "merge, export: file to write to, export writes to standard output by default")
+	var Prefixes []string
+	flag.Var((*stringList)(&Prefixes), "Prefix", "merge: root path to put files of input under, repeat for each input in order. See MERGE")
+	var Conflicts string
+	flag.StringVar(&Conflicts, "Conflicts", tallylib.MergeFail.String(), "merge: what to do with files of the same name and different sha1: fail, first, newest or rename")
+
+	var Json bool
+	flag.BoolVar(&Json, "Json", false, "diff, ls: print as JSON")
+	flag.BoolVar(&Json, "json", false, "same as -Json")
+	var Long bool
+	flag.BoolVar(&Long, "Long", false, "ls: show sizes in bytes, timestamps and sha1")
+	flag.BoolVar(&Long, "long", false, "same as -Long")
+	var Sort string
+	flag.StringVar(&Sort, "Sort", "name", "ls: sort folders and files by name, size or time")
+
+	var Format string
+	flag.StringVar(&Format, "Format", tallylib.ExportSha1sum.String(), "export: sha1sum, sfv, csv or json, see EXPORT")
+	flag.StringVar(&Format, "format", tallylib.ExportSha1sum.String(), "same as -Format")
 
 	var UpdateRecursive bool
 	flag.BoolVar(&UpdateRecursive, "UpdateRecursive", true, "update folders recursively")
@@ -167,22 +482,183 @@ BUGS
 	flag.IntVar(&MinDig, "MinDig", 0, "create intermediate .rscollections only from this folder level down. See DIG DEPTH")
 	flag.IntVar(&MaxDig, "MaxDig", -1, "create intermediate .rscollections up to this depth, -1 means infinite. See DIG DEPTH")
 
-	flag.Parse()
+	var command = "update"
+	var args = os.Args[1:]
+	if len(args) > 0 && commands[args[0]] {
+		command = args[0]
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	if command == "merge" {
+		var policy, err = parseConflictPolicy(Conflicts)
+		exitOnError(err)
+		var options = tallylib.MergeOptions{Prefixes: Prefixes, Conflicts: policy}
+		exitOnError(merge(Output, flag.Args(), options, config.StrictRetroShareFormat))
+		return
+	}
+
+	if command == "ls" {
+		exitOnError(ls(flag.Args(), Sort, Long, Json))
+		return
+	}
+
+	if command == "diff" {
+		var same, err = diff(flag.Args(), Json)
+		exitOnError(err)
+		if !same {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var flagRoot = configRoot{MinDig: MinDig, MaxDig: MaxDig, Config: config}
+	var file = &configFile{Interval: duration(defaultDaemonInterval), MinDig: MinDig, MaxDig: MaxDig, Config: config}
+	if ConfigFile != "" {
+		var err error
+		file, err = loadConfig(ConfigFile, flagRoot)
+		exitOnError(err)
+	}
+	var roots = selectRoots(file, flag.Args())
+	overrideWithFlags(roots, flagRoot)
 
-	tally.SetConfig(config)
 	tally.SetLog(os.Stdout)
+	var ctx = interruptibleContext()
+	var problems, failed bool
+
+	if command == "export" {
+		var format, err = tallylib.ParseExportFormat(Format)
+		exitOnError(err)
+		if len(roots) != 1 {
+			exitOnError(errors.New("export: expected single collection file, folder or root name"))
+		}
+		tally.SetLog(os.Stderr)
+		exitOnError(export(ctx, tally, roots[0], format, Output))
+		return
+	}
+
+	if command == "daemon" {
+		if len(roots) == 0 {
+			exitOnError(errors.New("daemon: no roots given on command line or in -config file"))
+		}
+		runDaemon(ctx, file.StatusFile, roots)
+		return
+	}
+
+	if command == "watch" {
+		var err = watch(ctx, roots)
+		if err != context.Canceled {
+			exitOnError(err)
+		}
+		return
+	}
 
-	for _, path := range flag.Args() {
-		path = filepath.Clean(path)
+	for _, root := range roots {
+		var path = root.Path
+		tally.SetConfig(root.Config)
+		if command == "verify" {
+			var ok, err = verify(ctx, tally, path)
+			exitOnError(err)
+			problems = problems || !ok
+			continue
+		}
+		var progress *progressLine
+		if Progress {
+			progress = newProgressLine(os.Stderr)
+			tally.SetProgressListener(progress)
+		}
 		var err error
 		if UpdateRecursive {
-			_, err = tally.UpdateRecursive(path, MinDig, MaxDig)
+			_, err = tally.UpdateRecursiveContext(ctx, path, root.MinDig, root.MaxDig)
 		} else {
-			_, err = tally.UpdateSingleDirectory(path, false)
+			_, err = tally.UpdateSingleDirectoryContext(ctx, path, false)
+		}
+		if progress != nil {
+			progress.Close()
+		}
+		if root.Config.DryRun {
+			printPlan(tally.GetPlan())
+		}
+		if _, ok := err.(*tallylib.MultiError); ok {
+			// Summary of every skipped subtree, other paths go on
+			fmt.Println(err)
+			failed = true
+			continue
+		}
+		exitOnError(err)
+	}
+	if failed {
+		os.Exit(-1)
+	}
+	if problems {
+		os.Exit(1)
+	}
+}
+
+// Known commands, see usage
+var commands = map[string]bool{
+	"update": true,
+	"verify": true,
+	"watch":  true,
+	"daemon": true,
+	"merge":  true,
+	"diff":   true,
+	"ls":     true,
+	"export": true,
+}
+
+func exitOnError(err error) {
+	if err == context.Canceled {
+		fmt.Println("Interrupted, collections written so far are consistent")
+		os.Exit(-1)
+	}
+	if err != nil {
+		fmt.Print(err)
+		os.Exit(-1)
+	}
+}
+
+// Flag that can be repeated, each value is appended to the list
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// Returns context which is cancelled on SIGINT or SIGTERM
+func interruptibleContext() context.Context {
+	var ctx, cancel = context.WithCancel(context.Background())
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	return ctx
+}
+
+func printPlan(plan tallylib.Plan) {
+	for _, planned := range plan.Collections {
+		if planned.Created {
+			fmt.Println(planned.CollectionFile, "(new)")
+		} else if planned.Deleted {
+			fmt.Println(planned.CollectionFile, "(removed)")
+		} else {
+			fmt.Println(planned.CollectionFile)
+		}
+		for _, name := range planned.Added {
+			fmt.Println("\t+", name)
+		}
+		for _, name := range planned.Removed {
+			fmt.Println("\t-", name)
 		}
-		if err != nil {
-			fmt.Print(err)
-			os.Exit(-1)
+		for _, name := range planned.Rehashed {
+			fmt.Println("\t*", name)
 		}
 	}
 }
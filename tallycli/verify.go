@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/borisshvonder/tally/tallylib"
+)
+
+// Prints problems found in collections under path, returns false if there
+// were any
+func verify(ctx context.Context, tally tallylib.Tally, path string) (bool, error) {
+	var report, err = tally.VerifyContext(ctx, path)
+	for _, problem := range report.Problems {
+		var file = problem.Fullpath
+		if file == "" {
+			file = problem.Name
+		}
+		switch {
+		case problem.Name == "":
+			fmt.Printf("%s\t%s\n", problem.Kind, problem.CollectionFile)
+		case problem.Kind == tallylib.VerifyMismatch:
+			fmt.Printf("%s\t%s\t(expected %s, got %s, in %s)\n",
+				problem.Kind, file, problem.Expected, problem.Actual, problem.CollectionFile)
+		default:
+			fmt.Printf("%s\t%s\t(in %s)\n", problem.Kind, file, problem.CollectionFile)
+		}
+	}
+	fmt.Printf("%s: %d files in %d collections verified, %d problems\n",
+		path, report.Files, report.Collections, len(report.Problems))
+	return len(report.Problems) == 0, err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"github.com/borisshvonder/tally/tallylib"
+	"os"
+)
+
+// Keeps collection trees of all roots up to date until ctx is done. Every
+// root gets its own Tally, since Tally is not safe for concurrent use
+func watch(ctx context.Context, roots []configRoot) error {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	var errs = make(chan error, len(roots))
+	for _, root := range roots {
+		var tally = tallylib.NewTally()
+		tally.SetConfig(root.Config)
+		tally.SetLog(os.Stdout)
+		go func(root configRoot) {
+			errs <- tally.Watch(ctx, root.Path, root.MinDig, root.MaxDig)
+		}(root)
+	}
+
+	var ret error
+	for range roots {
+		var err = <-errs
+		if ret == nil || ret == context.Canceled {
+			ret = err
+		}
+		// One failed watch stops the others
+		cancel()
+	}
+	return ret
+}
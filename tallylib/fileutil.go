@@ -0,0 +1,180 @@
+package tallylib
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Collections and other files are first written to "."+name+tempFileSuffix
+// in the same directory and then renamed over the target, see
+// WriteFileAtomic
+const tempFileSuffix = ".tally-tmp"
+
+const backupSuffix = ".bak"
+
+func tempFileFor(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+tempFileSuffix)
+}
+
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempFileSuffix)
+}
+
+// Version 1 is path.bak (the newest), version n>1 is path.bak.n
+func backupName(path string, version int) string {
+	if version <= 1 {
+		return path + backupSuffix
+	}
+	return path + backupSuffix + "." + strconv.Itoa(version)
+}
+
+// Returns name of the file which name is a backup of (see backupName) or
+// empty string if name does not look like a backup
+func backupOf(name string) string {
+	var idx = strings.LastIndex(name, backupSuffix)
+	if idx <= 0 {
+		return ""
+	}
+	var version = name[idx+len(backupSuffix):]
+	if version == "" {
+		return name[:idx]
+	}
+	if version[0] != '.' {
+		return ""
+	}
+	if _, err := strconv.Atoi(version[1:]); err != nil {
+		return ""
+	}
+	return name[:idx]
+}
+
+// Fails if file exists but cannot be opened for writing. Does not modify
+// the file
+func assertWritable(path string) error {
+	var file, err = os.OpenFile(path, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Keeps up to versions previous versions of path. The path itself is
+// left in place
+func rotateBackups(path string, versions int) error {
+	if versions <= 0 {
+		return nil
+	}
+	var _, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for version := versions; version > 1; version-- {
+		err = os.Rename(backupName(path, version-1), backupName(path, version))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	var newest = backupName(path, 1)
+	err = os.Remove(newest)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.Link(path, newest) == nil {
+		return nil
+	}
+	// Filesystem does not support hard links
+	return copyFile(path, newest)
+}
+
+func copyFile(from, to string) error {
+	var in, err = os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var out *os.File
+	out, err = os.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	var closeErr = out.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Writes file at path so it is never seen half-written: write gets a
+// temporary file in the same directory, which is synced and renamed over
+// path, keeping permissions of path if it exists. New file is created with
+// perm (before umask). Temporary file is removed on failure
+func WriteFileAtomic(path string, perm os.FileMode, write func(out io.Writer) error) error {
+	return writeFileAtomic(path, perm, write, nil)
+}
+
+// Same as WriteFileAtomic, but calls beforeReplace (if not nil) once
+// contents are written, right before temporary file is renamed over path
+func writeFileAtomic(path string, perm os.FileMode, write func(out io.Writer) error, beforeReplace func() error) error {
+	var tmp = tempFileFor(path)
+	var file, err = os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	var closeErr = file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && beforeReplace != nil {
+		err = beforeReplace()
+	}
+	if err == nil {
+		err = replaceFile(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Renames from over to keeping permissions of to (if it exists)
+func replaceFile(from, to string) error {
+	var stat, err = os.Stat(to)
+	if err == nil {
+		err = os.Chmod(from, stat.Mode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(from, to)
+	if err != nil {
+		return err
+	}
+	syncDirectory(filepath.Dir(to))
+	return nil
+}
+
+// Makes rename durable. Best effort since not every OS supports it
+func syncDirectory(directory string) {
+	var dir, err = os.Open(directory)
+	if err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+}
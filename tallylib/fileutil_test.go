@@ -0,0 +1,86 @@
+package tallylib
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_backupOf(t *testing.T) {
+	assertStringEquals(t, "a.rscollection", backupOf("a.rscollection.bak"))
+	assertStringEquals(t, "a.rscollection", backupOf("a.rscollection.bak.2"))
+	assertStringEquals(t, "a.bak", backupOf("a.bak.bak.10"))
+	assertStringEquals(t, "", backupOf("a.rscollection"))
+	assertStringEquals(t, "", backupOf("a.rscollection.bak.x"))
+	assertStringEquals(t, "", backupOf("a.bakery"))
+	assertStringEquals(t, "", backupOf(".bak"))
+}
+
+func Test_rotateBackups(t *testing.T) {
+	var tmpdir = mktmp("Test_rotateBackups")
+	defer os.RemoveAll(tmpdir)
+	var path = filepath.Join(tmpdir, "coll")
+
+	for _, contents := range []string{"v1", "v2", "v3", "v4"} {
+		var err = rotateBackups(path, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tmp = writefile(tmpdir, ".coll.tally-tmp", contents)
+		err = replaceFile(tmp, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertFileContents(t, "v4", path)
+	assertFileContents(t, "v3", filepath.Join(tmpdir, "coll.bak"))
+	assertFileContents(t, "v2", filepath.Join(tmpdir, "coll.bak.2"))
+	assertFileContents(t, "v1", filepath.Join(tmpdir, "coll.bak.3"))
+	assertPathNotExists(t, filepath.Join(tmpdir, "coll.bak.4"))
+	assertPathNotExists(t, filepath.Join(tmpdir, ".coll.tally-tmp"))
+}
+
+func Test_WriteFileAtomic(t *testing.T) {
+	var tmpdir = mktmp("Test_WriteFileAtomic")
+	defer os.RemoveAll(tmpdir)
+	var path = writefile(tmpdir, "status.json", "old")
+	os.Chmod(path, 0600)
+
+	var err = WriteFileAtomic(path, 0644, func(out io.Writer) error {
+		_, err := io.WriteString(out, "new")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileContents(t, "new", path)
+	var stat, _ = os.Stat(path)
+	if stat.Mode().Perm() != 0600 {
+		t.Error("permissions not kept:", stat.Mode())
+	}
+
+	var failure = errors.New("failed")
+	err = WriteFileAtomic(path, 0644, func(out io.Writer) error {
+		io.WriteString(out, "half")
+		return failure
+	})
+	if err != failure {
+		t.Error("expected write error, got", err)
+	}
+	assertFileContents(t, "new", path)
+	assertPathNotExists(t, tempFileFor(path))
+}
+
+func assertFileContents(t *testing.T, expected, path string) {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	assertStringEquals(t, expected, string(data))
+}
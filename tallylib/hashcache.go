@@ -0,0 +1,162 @@
+package tallylib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Remembers sha1 of files hashed before, so that files don't have to be
+// rehashed when collection has no usable timestamps (or no record at all).
+// Records are keyed by absolute path and are only trusted while size,
+// mtime and inode of the file stay the same. Safe for concurrent use.
+// All methods are no-op on nil cache
+type hashCache struct {
+	lock    sync.Mutex
+	path    string // file cache is kept in
+	root    string // absolute path of the tree cache is for
+	force   bool   // ignore existing records, see TallyConfig.ForceUpdate
+	entries map[string]hashCacheEntry
+	seen    map[string]bool // paths looked up or stored during this run
+	dirty   bool
+}
+
+type hashCacheEntry struct {
+	Size    int64
+	ModTime int64 // UnixNano
+	Inode   uint64
+	Sha1    string
+}
+
+// On-disk format of hashCache
+type hashCacheFile struct {
+	Root    string
+	Entries map[string]hashCacheEntry
+}
+
+// Cache file for the tree rooted at root is kept in directory, named
+// after sha1 of absolute root path
+func hashCacheFileFor(directory, root string) string {
+	var sum = sha1.Sum([]byte(root))
+	return filepath.Join(directory, hex.EncodeToString(sum[:])+".json")
+}
+
+// Loads cache of tree root from directory. Returns empty cache along with
+// an error if existing cache file cannot be read
+func loadHashCache(directory, root string) (*hashCache, error) {
+	var ret = new(hashCache)
+	ret.root = root
+	ret.path = hashCacheFileFor(directory, root)
+	ret.entries = make(map[string]hashCacheEntry)
+	ret.seen = make(map[string]bool)
+
+	var file, err = os.Open(ret.path)
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return ret, err
+	}
+	defer file.Close()
+
+	var contents hashCacheFile
+	err = json.NewDecoder(file).Decode(&contents)
+	if err != nil {
+		return ret, err
+	}
+	if contents.Root == root && contents.Entries != nil {
+		ret.entries = contents.Entries
+	}
+	return ret, nil
+}
+
+// Returns sha1 recorded for the file if file did not change since
+func (cache *hashCache) lookup(fullpath string, stat os.FileInfo) (string, bool) {
+	if cache == nil {
+		return "", false
+	}
+	var key, ok = cacheKey(fullpath)
+	if !ok {
+		return "", false
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.seen[key] = true
+	var entry, found = cache.entries[key]
+	if cache.force || !found || entry != newHashCacheEntry(stat, entry.Sha1) {
+		return "", false
+	}
+	return entry.Sha1, true
+}
+
+func (cache *hashCache) store(fullpath string, stat os.FileInfo, sha1sum string) {
+	if cache == nil {
+		return
+	}
+	var key, ok = cacheKey(fullpath)
+	if !ok {
+		return
+	}
+	var entry = newHashCacheEntry(stat, sha1sum)
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.seen[key] = true
+	if cache.entries[key] != entry {
+		cache.entries[key] = entry
+		cache.dirty = true
+	}
+}
+
+// Writes cache back if it changed. Records of files which no longer exist
+// are dropped
+func (cache *hashCache) save() error {
+	if cache == nil {
+		return nil
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for key := range cache.entries {
+		if cache.seen[key] {
+			continue
+		}
+		if _, err := os.Stat(key); os.IsNotExist(err) {
+			delete(cache.entries, key)
+			cache.dirty = true
+		}
+	}
+	if !cache.dirty {
+		return nil
+	}
+
+	var err = os.MkdirAll(filepath.Dir(cache.path), 0755)
+	if err != nil {
+		return err
+	}
+	var contents = hashCacheFile{Root: cache.root, Entries: cache.entries}
+	err = WriteFileAtomic(cache.path, 0644, func(out io.Writer) error {
+		return json.NewEncoder(out).Encode(&contents)
+	})
+	if err != nil {
+		return err
+	}
+	cache.dirty = false
+	return nil
+}
+
+func newHashCacheEntry(stat os.FileInfo, sha1sum string) hashCacheEntry {
+	return hashCacheEntry{
+		Size:    stat.Size(),
+		ModTime: stat.ModTime().UnixNano(),
+		Inode:   fileInode(stat),
+		Sha1:    sha1sum,
+	}
+}
+
+func cacheKey(fullpath string) (string, bool) {
+	var abs, err = filepath.Abs(fullpath)
+	return abs, err == nil
+}
@@ -0,0 +1,88 @@
+package tallylib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_hashCache_will_forget_changed_files(t *testing.T) {
+	tmpdir := mktmp("Test_hashCache_will_forget_changed_files")
+	defer os.RemoveAll(tmpdir)
+	cacheDir := filepath.Join(tmpdir, "cache")
+	path := writefile(tmpdir, "file1", "Hello, world!")
+	stat, _ := os.Stat(path)
+
+	var cache, err = loadHashCache(cacheDir, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.store(path, stat, "943a702d06f34599aee1f8da8ef9f7296031d699")
+	if err = cache.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = loadHashCache(cacheDir, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sha1sum, found = cache.lookup(path, stat)
+	if !found {
+		t.Fatal("file not found in cache")
+	}
+	assertStringEquals(t, "943a702d06f34599aee1f8da8ef9f7296031d699", sha1sum)
+
+	var later = stat.ModTime().Add(time.Second)
+	os.Chtimes(path, later, later)
+	stat, _ = os.Stat(path)
+	if _, found = cache.lookup(path, stat); found {
+		t.Error("modified file must not be found in cache")
+	}
+}
+
+func Test_hashCache_will_drop_removed_files(t *testing.T) {
+	tmpdir := mktmp("Test_hashCache_will_drop_removed_files")
+	defer os.RemoveAll(tmpdir)
+	cacheDir := filepath.Join(tmpdir, "cache")
+	path := writefile(tmpdir, "file1", "Hello, world!")
+	stat, _ := os.Stat(path)
+
+	var cache, _ = loadHashCache(cacheDir, tmpdir)
+	cache.store(path, stat, "943a702d06f34599aee1f8da8ef9f7296031d699")
+	cache.save()
+
+	os.Remove(path)
+	cache, _ = loadHashCache(cacheDir, tmpdir)
+	cache.save()
+	cache, _ = loadHashCache(cacheDir, tmpdir)
+	assertIntEquals(t, "cache entries", 0, len(cache.entries))
+}
+
+func Test_UpdateRecursive_will_not_rehash_cached_files(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_not_rehash_cached_files")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.HashCacheDir = filepath.Join(tmpdir, "cache")
+	fixture.SetConfig(config)
+
+	assertUpdateRecursive(t, fixture, subdir1)
+	os.Remove(resolveCollectionFileSimple(subdir1))
+
+	var listener = new(recordingListener)
+	fixture.SetProgressListener(listener)
+	var coll = assertUpdateRecursive(t, fixture, subdir1)
+	assertFileInCollection(t, coll, "file1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertIntEquals(t, "hashes started", 0, listener.hashesStarted)
+	assertUint64Equals(t, "bytes read", 0, listener.bytesRead)
+
+	config.ForceUpdate = true
+	fixture.SetConfig(config)
+	listener = new(recordingListener)
+	fixture.SetProgressListener(listener)
+	update(t, fixture, subdir1, true)
+	assertIntEquals(t, "hashes started with ForceUpdate", 1, listener.hashesStarted)
+}
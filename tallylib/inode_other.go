@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tallylib
+
+import "os"
+
+// Inode numbers are not available on this platform, size and mtime have
+// to be enough
+func fileInode(stat os.FileInfo) uint64 {
+	return 0
+}
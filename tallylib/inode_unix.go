@@ -0,0 +1,16 @@
+//go:build unix
+
+package tallylib
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns inode number of the file or 0 if it is not known
+func fileInode(stat os.FileInfo) uint64 {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Ino)
+	}
+	return 0
+}
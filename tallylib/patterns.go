@@ -0,0 +1,116 @@
+package tallylib
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Single gitignore-style pattern, see TallyConfig.Exclude for syntax
+type pattern struct {
+	text     string   // as written by user, for error messages
+	base     string   // filesystem directory anchored pattern is relative to
+	negate   bool     // pattern started with '!'
+	dirOnly  bool     // pattern ended with '/'
+	anchored bool     // pattern has '/' in the middle or at the beginning
+	segments []string // pattern split by '/'
+}
+
+// Ordered list of patterns, the last matching pattern wins
+type patternList []*pattern
+
+// Parses pattern. Returns nil for empty lines and comments
+func compilePattern(text, base string) (*pattern, error) {
+	var line = strings.TrimRight(text, " \t\r")
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+
+	var ret = new(pattern)
+	ret.text = text
+	ret.base = base
+	if line[0] == '!' {
+		ret.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		ret.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		ret.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	if line == "" {
+		return nil, nil
+	}
+	ret.segments = strings.Split(line, "/")
+
+	for _, segment := range ret.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Returns matched=true if any pattern matches fullpath, in this case
+// negated tells if the last matching pattern was negated
+func (list patternList) match(fullpath string, isDir bool) (matched, negated bool) {
+	for _, pattern := range list {
+		if pattern.match(fullpath, isDir) {
+			matched = true
+			negated = pattern.negate
+		}
+	}
+	return
+}
+
+func (pattern *pattern) match(fullpath string, isDir bool) bool {
+	if pattern.dirOnly && !isDir {
+		return false
+	}
+	if !pattern.anchored {
+		return matchSegment(pattern.segments[0], filepath.Base(fullpath))
+	}
+	if filepath.IsAbs(pattern.base) && !filepath.IsAbs(fullpath) {
+		var abs, err = filepath.Abs(fullpath)
+		if err != nil {
+			return false
+		}
+		fullpath = abs
+	}
+	var rel, err = filepath.Rel(pattern.base, fullpath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return matchSegments(pattern.segments, strings.Split(filepath.ToSlash(rel), "/"))
+}
+
+// Matches path segments, "**" matches any number of segments (even zero)
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			var rest = pattern[1:]
+			for skip := 0; skip <= len(path); skip++ {
+				if matchSegments(rest, path[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 || !matchSegment(pattern[0], path[0]) {
+			return false
+		}
+		pattern = pattern[1:]
+		path = path[1:]
+	}
+	return len(path) == 0
+}
+
+func matchSegment(pattern, name string) bool {
+	var matched, _ = path.Match(pattern, name)
+	return matched
+}
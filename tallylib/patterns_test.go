@@ -0,0 +1,90 @@
+package tallylib
+
+import (
+	"testing"
+)
+
+func Test_pattern_unanchored(t *testing.T) {
+	assertPatternMatches(t, true, "*.part", "/root/a/b/file.part", false)
+	assertPatternMatches(t, true, ".DS_Store", "/root/.DS_Store", false)
+	assertPatternMatches(t, false, "*.part", "/root/a/file.mp3", false)
+	assertPatternMatches(t, true, "\\!important", "/root/!important", false)
+}
+
+func Test_pattern_dirOnly(t *testing.T) {
+	assertPatternMatches(t, true, ".git/", "/root/a/.git", true)
+	assertPatternMatches(t, false, ".git/", "/root/a/.git", false)
+}
+
+func Test_pattern_anchored(t *testing.T) {
+	assertPatternMatches(t, true, "/tmp", "/root/tmp", true)
+	assertPatternMatches(t, false, "/tmp", "/root/a/tmp", true)
+	assertPatternMatches(t, true, "a/*.txt", "/root/a/lyrics.txt", false)
+	assertPatternMatches(t, false, "a/*.txt", "/root/b/a/lyrics.txt", false)
+	assertPatternMatches(t, false, "/tmp", "/elsewhere/tmp", true)
+}
+
+func Test_pattern_doubleStar(t *testing.T) {
+	assertPatternMatches(t, true, "**/Lyrics/*.txt", "/root/Artist/Album/Lyrics/01.txt", false)
+	assertPatternMatches(t, true, "**/Lyrics/*.txt", "/root/Lyrics/01.txt", false)
+	assertPatternMatches(t, true, "Artist/**/cover.jpg", "/root/Artist/cover.jpg", false)
+	assertPatternMatches(t, true, "Artist/**/cover.jpg", "/root/Artist/A/B/cover.jpg", false)
+	assertPatternMatches(t, false, "Artist/**/cover.jpg", "/root/Other/A/cover.jpg", false)
+	assertPatternMatches(t, true, "Artist/**", "/root/Artist/A/B", false)
+}
+
+func Test_patternList_last_match_wins(t *testing.T) {
+	var list = patternList{
+		mustCompilePattern("*.part", "/root"),
+		mustCompilePattern("!keep.part", "/root"),
+	}
+	var matched, negated = list.match("/root/keep.part", false)
+	if !matched || !negated {
+		t.Log("keep.part should be re-included")
+		t.Fail()
+	}
+	matched, negated = list.match("/root/other.part", false)
+	if !matched || negated {
+		t.Log("other.part should be excluded")
+		t.Fail()
+	}
+	matched, _ = list.match("/root/file.mp3", false)
+	if matched {
+		t.Log("file.mp3 should not match")
+		t.Fail()
+	}
+}
+
+func Test_compilePattern_comments_and_errors(t *testing.T) {
+	var pattern, err = compilePattern("# comment", "/root")
+	if pattern != nil || err != nil {
+		t.Log("Comments should be skipped")
+		t.Fail()
+	}
+	pattern, err = compilePattern("   ", "/root")
+	if pattern != nil || err != nil {
+		t.Log("Empty lines should be skipped")
+		t.Fail()
+	}
+	_, err = compilePattern("[invalid", "/root")
+	if err == nil {
+		t.Log("Should fail on invalid pattern")
+		t.Fail()
+	}
+}
+
+func assertPatternMatches(t *testing.T, expected bool, text, fullpath string, isDir bool) {
+	var pattern = mustCompilePattern(text, "/root")
+	if pattern.match(fullpath, isDir) != expected {
+		t.Log("Pattern", text, "match", fullpath, "expected to be", expected)
+		t.Fail()
+	}
+}
+
+func mustCompilePattern(text, base string) *pattern {
+	var ret, err = compilePattern(text, base)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
@@ -0,0 +1,108 @@
+package tallylib
+
+import (
+	"sort"
+)
+
+// Difference between two collections, see DiffCollections. Every list is
+// sorted by Name
+type CollectionDiff struct {
+	Added   []DiffEntry // entries only in new collection
+	Removed []DiffEntry // entries only in old collection
+	Renamed []DiffEntry // same sha1 under different name
+	Changed []DiffEntry // same name with different sha1
+}
+
+type DiffEntry struct {
+	Name    string // name in new collection, in old one for Removed
+	OldName string // name in old collection, set for Renamed only
+	Sha1    string // sha1 in new collection, in old one for Removed
+	OldSha1 string // sha1 in old collection, set for Changed only
+	Size    int64  // size in new collection, in old one for Removed
+}
+
+// Returns true if collections have the same names with the same sha1
+func (diff *CollectionDiff) Empty() bool {
+	return len(diff.Added)+len(diff.Removed)+len(diff.Renamed)+len(diff.Changed) == 0
+}
+
+// Compares old collection a with new collection b. Only names and sha1 are
+// compared, sizes and timestamps are not. Entry removed from a and entry
+// added to b with the same sha1 are reported as renamed; if sha1 is shared
+// by several of them, they are paired in name order
+func DiffCollections(a, b RSCollection) CollectionDiff {
+	var ret = CollectionDiff{
+		Added:   []DiffEntry{},
+		Removed: []DiffEntry{},
+		Renamed: []DiffEntry{},
+		Changed: []DiffEntry{},
+	}
+	var removed = make(map[string][]RSCollectionFile) // by sha1
+	var added = make(map[string][]RSCollectionFile)   // by sha1
+
+	a.Visit(func(old RSCollectionFile) {
+		var file = b.ByName(old.Name())
+		if file == nil {
+			removed[old.Sha1()] = append(removed[old.Sha1()], old)
+		} else if file.Sha1() != old.Sha1() {
+			ret.Changed = append(ret.Changed, DiffEntry{
+				Name:    file.Name(),
+				Sha1:    file.Sha1(),
+				OldSha1: old.Sha1(),
+				Size:    file.Size(),
+			})
+		}
+	})
+	b.Visit(func(file RSCollectionFile) {
+		if a.ByName(file.Name()) == nil {
+			added[file.Sha1()] = append(added[file.Sha1()], file)
+		}
+	})
+
+	for sha1, files := range added {
+		var olds = removed[sha1]
+		sortFiles(files)
+		sortFiles(olds)
+		var renamed = 0
+		if sha1 != "" {
+			renamed = len(files)
+			if len(olds) < renamed {
+				renamed = len(olds)
+			}
+		}
+		for i := 0; i < renamed; i++ {
+			ret.Renamed = append(ret.Renamed, DiffEntry{
+				Name:    files[i].Name(),
+				OldName: olds[i].Name(),
+				Sha1:    sha1,
+				Size:    files[i].Size(),
+			})
+		}
+		for _, file := range files[renamed:] {
+			ret.Added = append(ret.Added, diffEntry(file))
+		}
+		removed[sha1] = olds[renamed:]
+	}
+	for _, olds := range removed {
+		for _, old := range olds {
+			ret.Removed = append(ret.Removed, diffEntry(old))
+		}
+	}
+
+	for _, entries := range [][]DiffEntry{ret.Added, ret.Removed, ret.Renamed, ret.Changed} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name < entries[j].Name
+		})
+	}
+	return ret
+}
+
+func diffEntry(file RSCollectionFile) DiffEntry {
+	return DiffEntry{Name: file.Name(), Sha1: file.Sha1(), Size: file.Size()}
+}
+
+func sortFiles(files []RSCollectionFile) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+}
@@ -0,0 +1,53 @@
+package tallylib
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_DiffCollections_will_report_changes(t *testing.T) {
+	var a = NewCollection()
+	a.InitEmpty()
+	a.Update("same", "sha1same", 1, time.Time{})
+	a.Update("touched", "sha1touched", 1, time.Time{})
+	a.Update("changed", "sha1old", 2, time.Time{})
+	a.Update("gone", "sha1gone", 3, time.Time{})
+	a.Update("dir/moved", "sha1moved", 4, time.Time{})
+	a.Update("copy1", "sha1copy", 5, time.Time{})
+	a.Update("copy2", "sha1copy", 5, time.Time{})
+
+	var b = NewCollection()
+	b.InitEmpty()
+	b.Update("same", "sha1same", 1, time.Time{})
+	b.Update("touched", "sha1touched", 10, time.Now())
+	b.Update("changed", "sha1new", 20, time.Time{})
+	b.Update("new", "sha1new", 6, time.Time{})
+	b.Update("other/moved", "sha1moved", 4, time.Time{})
+	b.Update("copy0", "sha1copy", 5, time.Time{})
+
+	var diff = DiffCollections(a, b)
+	assertDiffEntries(t, "added", []string{"new"}, diff.Added)
+	assertDiffEntries(t, "removed", []string{"copy2", "gone"}, diff.Removed)
+	assertDiffEntries(t, "renamed", []string{"copy0", "other/moved"}, diff.Renamed)
+	assertStringEquals(t, "copy1", diff.Renamed[0].OldName)
+	assertStringEquals(t, "dir/moved", diff.Renamed[1].OldName)
+	assertDiffEntries(t, "changed", []string{"changed"}, diff.Changed)
+	assertStringEquals(t, "sha1old", diff.Changed[0].OldSha1)
+	assertStringEquals(t, "sha1new", diff.Changed[0].Sha1)
+	if diff.Empty() {
+		t.Error("diff should not be empty")
+	}
+
+	diff = DiffCollections(b, b)
+	if !diff.Empty() {
+		t.Error("collection should not differ from itself", diff)
+	}
+}
+
+func assertDiffEntries(t *testing.T, what string, expected []string, actual []DiffEntry) {
+	var names []string
+	for _, entry := range actual {
+		names = append(names, entry.Name)
+	}
+	assertPlannedEntries(t, what, expected, names)
+}
@@ -0,0 +1,184 @@
+package tallylib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listing formats understood by other tools, see WriteExport
+type ExportFormat int
+
+const (
+	ExportSha1sum ExportFormat = iota // checked with sha1sum -c
+	ExportSFV                         // Simple File Verification, CRC32
+	ExportCSV                         // Path,Sha1,Size,Updated with header
+	ExportJSON                        // array of {Path, Sha1, Size, Updated}
+)
+
+var exportFormats = []ExportFormat{ExportSha1sum, ExportSFV, ExportCSV, ExportJSON}
+
+func (format ExportFormat) String() string {
+	switch format {
+	case ExportSha1sum:
+		return "sha1sum"
+	case ExportSFV:
+		return "sfv"
+	case ExportCSV:
+		return "csv"
+	case ExportJSON:
+		return "json"
+	}
+	return "unknown"
+}
+
+// Returns format which String() is name
+func ParseExportFormat(name string) (ExportFormat, error) {
+	var names []string
+	for _, format := range exportFormats {
+		if format.String() == name {
+			return format, nil
+		}
+		names = append(names, format.String())
+	}
+	return ExportSha1sum, errors.New("unknown export format " + strconv.Quote(name) +
+		", expected one of " + strings.Join(names, ", "))
+}
+
+// Single file of exported listing
+type ExportEntry struct {
+	Path      string    // slash-separated, relative to exported collection or folder
+	Sha1      string    // lowercase hex
+	Size      int64     // 0 if unknown
+	Timestamp time.Time // zero if unknown
+}
+
+// Returns entries of coll sorted by name, paths are entry names
+func ExportEntries(coll RSCollection) []ExportEntry {
+	var ret = make([]ExportEntry, 0, coll.Size())
+	coll.Visit(func(file RSCollectionFile) {
+		ret = append(ret, exportEntry(file.Name(), file))
+	})
+	sortExportEntries(ret)
+	return ret
+}
+
+func exportEntry(path string, file RSCollectionFile) ExportEntry {
+	return ExportEntry{Path: path, Sha1: file.Sha1(), Size: file.Size(), Timestamp: file.Timestamp()}
+}
+
+func sortExportEntries(entries []ExportEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+}
+
+// Writes entries to out in given format. Collections do not store CRC32
+// needed by SFV, so for ExportSFV files are read from directory entry paths
+// are relative to. Other formats do not use directory
+func WriteExport(out io.Writer, entries []ExportEntry, format ExportFormat, directory string) error {
+	switch format {
+	case ExportSha1sum:
+		return writeSha1sum(out, entries)
+	case ExportSFV:
+		return writeSFV(out, entries, directory)
+	case ExportCSV:
+		return writeCSV(out, entries)
+	case ExportJSON:
+		return writeJSON(out, entries)
+	}
+	return errors.New("unknown export format " + strconv.Itoa(int(format)))
+}
+
+// Escapes names the way GNU sha1sum does
+func writeSha1sum(out io.Writer, entries []ExportEntry) error {
+	for _, entry := range entries {
+		var line = entry.Sha1 + "  " + entry.Path + "\n"
+		if strings.ContainsAny(entry.Path, "\\\n\r") {
+			var escaped = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(entry.Path)
+			line = "\\" + entry.Sha1 + "  " + escaped + "\n"
+		}
+		var _, err = io.WriteString(out, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSFV(out io.Writer, entries []ExportEntry, directory string) error {
+	if directory == "" {
+		return errors.New("SFV needs CRC32 which collections do not store, export folder with the files instead")
+	}
+	var _, err = io.WriteString(out, "; Generated by tally\n")
+	for _, entry := range entries {
+		if err != nil {
+			return err
+		}
+		var fullpath = filepath.Join(directory, filepath.FromSlash(entry.Path))
+		var sum uint32
+		sum, err = fileCRC32(fullpath)
+		if err != nil {
+			return newAccessError(kindOf(err), fullpath, "Cannot compute CRC32", err)
+		}
+		_, err = fmt.Fprintf(out, "%s %08X\n", entry.Path, sum)
+	}
+	return err
+}
+
+func fileCRC32(fullpath string) (uint32, error) {
+	var in, err = os.Open(fullpath)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	var hash = crc32.NewIEEE()
+	_, err = io.Copy(hash, in)
+	return hash.Sum32(), err
+}
+
+func writeCSV(out io.Writer, entries []ExportEntry) error {
+	var writer = csv.NewWriter(out)
+	writer.Write([]string{"Path", "Sha1", "Size", "Updated"})
+	for _, entry := range entries {
+		writer.Write([]string{entry.Path, entry.Sha1, strconv.FormatInt(entry.Size, 10), formatUpdated(entry.Timestamp)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type jsonExportEntry struct {
+	Path    string
+	Sha1    string
+	Size    int64
+	Updated string `json:",omitempty"`
+}
+
+func writeJSON(out io.Writer, entries []ExportEntry) error {
+	var list = make([]jsonExportEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, jsonExportEntry{entry.Path, entry.Sha1, entry.Size, formatUpdated(entry.Timestamp)})
+	}
+	var data, err = json.MarshalIndent(list, "", "\t")
+	if err == nil {
+		_, err = out.Write(append(data, '\n'))
+	}
+	return err
+}
+
+// Same format as "updated" attribute of collection files
+func formatUpdated(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,82 @@
+package tallylib
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportFixture() []ExportEntry {
+	var coll = NewCollection()
+	coll.InitEmpty()
+	coll.Update("dir/b.txt", "sha1b", 20, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	coll.Update("a, \"quoted\"", "sha1a", 10, time.Time{})
+	coll.Update("back\\slash", "sha1c", 30, time.Time{})
+	return ExportEntries(coll)
+}
+
+func Test_WriteExport_will_write_sha1sum(t *testing.T) {
+	var out strings.Builder
+	var err = WriteExport(&out, exportFixture(), ExportSha1sum, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEquals(t, "sha1a  a, \"quoted\"\n"+
+		"\\sha1c  back\\\\slash\n"+
+		"sha1b  dir/b.txt\n", out.String())
+}
+
+func Test_WriteExport_will_write_csv_and_json(t *testing.T) {
+	var out strings.Builder
+	var err = WriteExport(&out, exportFixture(), ExportCSV, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEquals(t, "Path,Sha1,Size,Updated\n"+
+		"\"a, \"\"quoted\"\"\",sha1a,10,\n"+
+		"back\\slash,sha1c,30,\n"+
+		"dir/b.txt,sha1b,20,2021-06-01T00:00:00Z\n", out.String())
+
+	out.Reset()
+	err = WriteExport(&out, exportFixture()[2:], ExportJSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEquals(t, "[\n\t{\n\t\t\"Path\": \"dir/b.txt\",\n\t\t\"Sha1\": \"sha1b\",\n\t\t\"Size\": 20,\n"+
+		"\t\t\"Updated\": \"2021-06-01T00:00:00Z\"\n\t}\n]\n", out.String())
+}
+
+func Test_WriteExport_will_compute_crc32_for_sfv(t *testing.T) {
+	tmpdir := mktmp("Test_WriteExport_will_compute_crc32_for_sfv")
+	defer os.RemoveAll(tmpdir)
+	writefile(mkdir(tmpdir, "dir"), "file", "Hello, world!")
+
+	var entries = []ExportEntry{{Path: "dir/file", Sha1: "943a702d06f34599aee1f8da8ef9f7296031d699"}}
+	var out strings.Builder
+	var err = WriteExport(&out, entries, ExportSFV, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEquals(t, "; Generated by tally\ndir/file EBE6C6E6\n", out.String())
+
+	err = WriteExport(&out, entries, ExportSFV, "")
+	if err == nil {
+		t.Error("SFV cannot be written without files")
+	}
+	entries[0].Path = "missing"
+	err = WriteExport(&out, entries, ExportSFV, tmpdir)
+	assertErrorKind(t, ErrNotFound, err)
+}
+
+func Test_ParseExportFormat(t *testing.T) {
+	for _, format := range exportFormats {
+		var parsed, err = ParseExportFormat(format.String())
+		if err != nil || parsed != format {
+			t.Error(format, "parsed as", parsed, err)
+		}
+	}
+	if _, err := ParseExportFormat("xml"); err == nil {
+		t.Error("xml is not supported")
+	}
+}
@@ -0,0 +1,112 @@
+package tallylib
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// What MergeCollections does when the same name has different sha1 in
+// different collections
+type MergeConflictPolicy int
+
+const (
+	MergeFail       MergeConflictPolicy = iota // fail with ErrMergeConflict
+	MergeKeepFirst                             // keep entry of collection listed first
+	MergeKeepNewest                            // keep entry with the latest timestamp
+	MergeRename                                // keep both, later one as "name (2).ext"
+)
+
+func (policy MergeConflictPolicy) String() string {
+	switch policy {
+	case MergeFail:
+		return "fail"
+	case MergeKeepFirst:
+		return "first"
+	case MergeKeepNewest:
+		return "newest"
+	case MergeRename:
+		return "rename"
+	}
+	return "unknown"
+}
+
+type MergeOptions struct {
+	// Root paths to put entries of inputs under, by input index. Inputs
+	// without prefix (or with empty one) keep their names as is
+	Prefixes []string
+
+	Conflicts MergeConflictPolicy
+}
+
+// Merges inputs into new collection. Inputs are not modified. Entries with
+// the same name and sha1 are merged into one, the first one is kept
+func MergeCollections(inputs []RSCollection, options MergeOptions) (RSCollection, error) {
+	var ret = NewCollection()
+	ret.InitEmpty()
+	for i, input := range inputs {
+		var prefix string
+		if i < len(options.Prefixes) {
+			prefix = strings.Trim(options.Prefixes[i], "/")
+		}
+		var names = make([]string, 0, input.Size())
+		input.Visit(func(file RSCollectionFile) {
+			names = append(names, file.Name())
+		})
+		sort.Strings(names)
+
+		for _, name := range names {
+			var file = input.ByName(name)
+			var merged = colljoin(prefix, name)
+			if merged != name {
+				file = newFile(merged, file.Sha1(), file.Size(), file.Timestamp())
+			}
+			var err = mergeFile(ret, file, options.Conflicts)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
+
+func mergeFile(coll RSCollection, file RSCollectionFile, policy MergeConflictPolicy) error {
+	var existing = coll.ByName(file.Name())
+	if existing == nil {
+		coll.UpdateFile(file)
+		return nil
+	}
+	if existing.Sha1() == file.Sha1() {
+		return nil
+	}
+	switch policy {
+	case MergeKeepFirst:
+	case MergeKeepNewest:
+		if file.Timestamp().After(existing.Timestamp()) {
+			coll.UpdateFile(file)
+		}
+	case MergeRename:
+		var renamed = newFile(freeName(coll, file.Name()), file.Sha1(), file.Size(), file.Timestamp())
+		coll.UpdateFile(renamed)
+	default:
+		return newAccessError(ErrMergeConflict, file.Name(),
+			"has different sha1 in merged collections: "+existing.Sha1()+" and "+file.Sha1(), nil)
+	}
+	return nil
+}
+
+// Returns "dir/name (n).ext" with the smallest n>=2 not used in coll
+func freeName(coll RSCollection, name string) string {
+	var ext = path.Ext(name)
+	if strings.Contains(ext, "/") || ext == path.Base(name) {
+		ext = ""
+	}
+	var stem = strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		var candidate = stem + " (" + strconv.Itoa(n) + ")" + ext
+		if coll.ByName(candidate) == nil {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package tallylib
+
+import (
+	"testing"
+	"time"
+)
+
+func mergeFixture() []RSCollection {
+	var a = NewCollection()
+	a.InitEmpty()
+	a.Update("same", "sha1same", 1, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	a.Update("dir/conflict.txt", "sha1a", 2, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	a.Update("onlya", "sha1onlya", 3, time.Time{})
+
+	var b = NewCollection()
+	b.InitEmpty()
+	b.Update("same", "sha1same", 1, time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC))
+	b.Update("dir/conflict.txt", "sha1b", 4, time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC))
+	b.Update("dir/conflict (2).txt", "sha1c", 5, time.Time{})
+	return []RSCollection{a, b}
+}
+
+func Test_MergeCollections_will_fail_on_conflict(t *testing.T) {
+	var _, err = MergeCollections(mergeFixture(), MergeOptions{})
+	assertErrorKind(t, ErrMergeConflict, err)
+}
+
+func Test_MergeCollections_will_resolve_conflicts(t *testing.T) {
+	var merged, err = MergeCollections(mergeFixture(), MergeOptions{Conflicts: MergeKeepFirst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileInCollection(t, merged, "same", "sha1same")
+	assertFileInCollection(t, merged, "dir/conflict.txt", "sha1a")
+	assertFileInCollection(t, merged, "onlya", "sha1onlya")
+	assertFileInCollection(t, merged, "dir/conflict (2).txt", "sha1c")
+	assertCollectionSize(t, 4, merged)
+
+	merged, err = MergeCollections(mergeFixture(), MergeOptions{Conflicts: MergeKeepNewest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileInCollection(t, merged, "dir/conflict.txt", "sha1b")
+	assertIntEquals(t, "size", 4, int(merged.ByName("dir/conflict.txt").Size()))
+	assertCollectionSize(t, 4, merged)
+
+	merged, err = MergeCollections(mergeFixture(), MergeOptions{Conflicts: MergeRename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileInCollection(t, merged, "dir/conflict.txt", "sha1a")
+	assertFileInCollection(t, merged, "dir/conflict (2).txt", "sha1c")
+	assertFileInCollection(t, merged, "dir/conflict (3).txt", "sha1b")
+	assertCollectionSize(t, 5, merged)
+}
+
+func Test_MergeCollections_will_prefix_inputs(t *testing.T) {
+	var merged, err = MergeCollections(mergeFixture(), MergeOptions{Prefixes: []string{"/a/", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileInCollection(t, merged, "a/same", "sha1same")
+	assertFileInCollection(t, merged, "a/dir/conflict.txt", "sha1a")
+	assertFileInCollection(t, merged, "b/same", "sha1same")
+	assertFileInCollection(t, merged, "b/dir/conflict.txt", "sha1b")
+	assertCollectionSize(t, 6, merged)
+
+	merged, err = MergeCollections(mergeFixture(), MergeOptions{Prefixes: []string{"a"}, Conflicts: MergeFail})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileInCollection(t, merged, "a/onlya", "sha1onlya")
+	assertFileInCollection(t, merged, "dir/conflict.txt", "sha1b")
+	assertCollectionSize(t, 6, merged)
+}
+
+func Test_freeName(t *testing.T) {
+	var coll = NewCollection()
+	coll.InitEmpty()
+	assertStringEquals(t, "dir.d/name (2)", freeName(coll, "dir.d/name"))
+	assertStringEquals(t, "dir/.hidden (2)", freeName(coll, "dir/.hidden"))
+	assertStringEquals(t, "a/b (2).tar", freeName(coll, "a/b.tar"))
+}
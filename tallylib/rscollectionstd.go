@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"sort"
 	"time"
 	"strings"
 )
@@ -36,17 +37,20 @@ func (coll *collection) Size() int {
 }
 
 func (coll *collection) Update(name, sha1 string, size int64, timestamp time.Time) RSCollectionFile {
-	var file = new(file)
-	file.name = name
-	file.sha1 = sha1
-	file.size = size
-	file.timestamp = timestamp
-
+	var file = newFile(name, sha1, size, timestamp)
 	coll.files[name] = file
-
 	return file
 }
 
+func newFile(name, sha1 string, size int64, timestamp time.Time) *file {
+	var ret = new(file)
+	ret.name = name
+	ret.sha1 = sha1
+	ret.size = size
+	ret.timestamp = timestamp
+	return ret
+}
+
 func (coll *collection) RemoveFile(name string) {
 	delete(coll.files, name)
 }
@@ -133,20 +137,7 @@ func (coll *collection) loadFiles(prefix string, files []*XmlFile, errs *strings
 }
 
 func (coll *collection) StoreTo(out io.Writer) error {
-	var xmlColl = new(XmlRsCollection)
-	xmlColl.Files = make([]*XmlFile, len(coll.files))
-
-	for _, v := range coll.files {
-		var xmlFile = stdFileToXml(v)
-		var path = collsplit(xmlFile.Name)
-		if len(path) == 1 {
-			xmlColl.Files = appendFileToSlice(xmlColl.Files, xmlFile)
-		} else {
-			var directory = findDirectory(xmlColl, path[:len(path)-1])
-			xmlFile.Name = path[len(path)-1]
-			directory.Files = appendFileToSlice(directory.Files, xmlFile)
-		}
-	}
+	var xmlColl = collectionToXml(coll)
 
 	var _, err = out.Write([]byte(xmlHeader))
 
@@ -160,6 +151,30 @@ func (coll *collection) StoreTo(out io.Writer) error {
 	return err
 }
 
+// Builds XML tree with files sorted by name, so that the same collection
+// is always stored the same way
+func collectionToXml(coll RSCollection) *XmlRsCollection {
+	var xmlColl = new(XmlRsCollection)
+	var names = make([]string, 0, coll.Size())
+	coll.Visit(func(file RSCollectionFile) {
+		names = append(names, file.Name())
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		var xmlFile = stdFileToXml(coll.ByName(name))
+		var path = collsplit(xmlFile.Name)
+		if len(path) == 1 {
+			xmlColl.Files = appendFileToSlice(xmlColl.Files, xmlFile)
+		} else {
+			var directory = findDirectory(xmlColl, path[:len(path)-1])
+			xmlFile.Name = path[len(path)-1]
+			directory.Files = appendFileToSlice(directory.Files, xmlFile)
+		}
+	}
+	return xmlColl
+}
+
 func findDirectory(xmlColl *XmlRsCollection, path [] string) *XmlDirectory {
 	var dirs = xmlColl.Directories
 	var firstName = path[0]
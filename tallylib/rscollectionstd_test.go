@@ -286,6 +286,52 @@ func Test_StoreTo_Subdirectories(t *testing.T) {
 
 }
 
+func Test_StoreStrict_emptyCollection(t *testing.T) {
+	var coll = NewCollection()
+	coll.InitEmpty()
+	var buf bytes.Buffer
+	StoreStrict(coll, &buf)
+
+	assertStrEquals(t, "StoreStrict()", "<!DOCTYPE RsCollection>\n<RsCollection/>\n", buf.String())
+}
+
+// Layout RetroShare sources describe, see StoreStrict
+func Test_StoreStrict_Subdirectories(t *testing.T) {
+	var coll = NewCollection()
+	coll.InitEmpty()
+	var timestamp = time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	coll.Update("name0", "sha0", 10240, timestamp)
+	coll.Update("dir1/name1", "sha1", 10241, timestamp)
+	coll.Update("dir1/dir2/name2", "sha2", 10242, timestamp)
+	coll.Update("dir1/dir2/dir3/a \"quoted\" & <odd>", "sha3", 10243, timestamp)
+
+	var buf bytes.Buffer
+	StoreStrict(coll, &buf)
+
+	assertStrEquals(t, "StoreStrict()",
+		`<!DOCTYPE RsCollection>
+<RsCollection>
+ <Directory name="dir1">
+  <Directory name="dir2">
+   <Directory name="dir3">
+    <File name="a &quot;quoted&quot; &amp; &lt;odd&gt;" sha1="sha3" size="10243"/>
+   </Directory>
+   <File name="name2" sha1="sha2" size="10242"/>
+  </Directory>
+  <File name="name1" sha1="sha1" size="10241"/>
+ </Directory>
+ <File name="name0" sha1="sha0" size="10240"/>
+</RsCollection>
+`, buf.String())
+
+	var loaded, err = loadCollectionFromString(buf.String())
+	if err != nil {
+		failOnError(t, err)
+	}
+	assertIntEquals(t, "loaded.Size()", 4, loaded.Size())
+	assertFile(t, loaded.ByName("dir1/dir2/dir3/a \"quoted\" & <odd>"), "dir1/dir2/dir3/a \"quoted\" & <odd>", "sha3", 10243, time.Time{})
+}
+
 func failOnError(t *testing.T, err error) {
 	t.Log(err.Error())
 	t.Fail()
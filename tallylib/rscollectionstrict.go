@@ -0,0 +1,64 @@
+package tallylib
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RetroShare writes collections with QDomDocument::toString(): one space
+// indent per level, attributes in the order name, sha1, size, empty
+// elements self-closed
+const strictIndent = " "
+
+var strictAttrEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\"", "&quot;",
+	"\t", "&#x9;",
+	"\n", "&#xa;",
+	"\r", "&#xd;",
+)
+
+// Stores coll the way RetroShare itself exports collections. Non-standard
+// attributes (file timestamps) are left out, so collection loaded back has
+// zero timestamps. Works with any RSCollection implementation.
+//
+// The layout follows RetroShare sources (QDomDocument::toString()); it is
+// NOT validated against files exported by a real RetroShare instance, tests
+// only check it against the expected layout and load it back
+func StoreStrict(coll RSCollection, out io.Writer) error {
+	var xmlColl = collectionToXml(coll)
+	var writer = bufio.NewWriter(out)
+
+	writer.WriteString(xmlHeader)
+	if len(xmlColl.Directories) == 0 && len(xmlColl.Files) == 0 {
+		writer.WriteString("<RsCollection/>\n")
+	} else {
+		writer.WriteString("<RsCollection>\n")
+		writeStrictEntries(writer, strictIndent, xmlColl.Directories, xmlColl.Files)
+		writer.WriteString("</RsCollection>\n")
+	}
+	return writer.Flush()
+}
+
+func writeStrictEntries(writer *bufio.Writer, indent string, directories []*XmlDirectory, files []*XmlFile) {
+	for _, directory := range directories {
+		writer.WriteString(indent + "<Directory name=\"" + strictAttrEscaper.Replace(directory.Name) + "\"")
+		if len(directory.Directories) == 0 && len(directory.Files) == 0 {
+			writer.WriteString("/>\n")
+			continue
+		}
+		writer.WriteString(">\n")
+		writeStrictEntries(writer, indent+strictIndent, directory.Directories, directory.Files)
+		writer.WriteString(indent + "</Directory>\n")
+	}
+	for _, file := range files {
+		writer.WriteString(indent +
+			"<File name=\"" + strictAttrEscaper.Replace(file.Name) +
+			"\" sha1=\"" + strictAttrEscaper.Replace(file.Sha1) +
+			"\" size=\"" + strconv.FormatInt(file.Size, 10) + "\"/>\n")
+	}
+}
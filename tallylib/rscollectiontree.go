@@ -0,0 +1,99 @@
+package tallylib
+
+import (
+	"sort"
+	"time"
+)
+
+// Directory of collection entries, the way they are nested in XmlDirectory
+// elements, see CollectionTree
+type CollectionDirectory struct {
+	Name        string                 // last component of path, "" for root
+	Path        string                 // path inside collection, "" for root
+	Directories []*CollectionDirectory // subdirectories
+	Files       []RSCollectionFile     // files directly in this directory
+
+	// Totals of this directory and all subdirectories
+	FileCount     int
+	TotalSize     int64
+	NewestModTime time.Time // zero if no file has timestamp
+}
+
+type TreeOrder int
+
+const (
+	OrderByName TreeOrder = iota // name, ascending
+	OrderBySize                  // size or TotalSize, biggest first
+	OrderByTime                  // timestamp or NewestModTime, newest first
+)
+
+// Builds directory tree of coll entries, sorted by name
+func CollectionTree(coll RSCollection) *CollectionDirectory {
+	var root = new(CollectionDirectory)
+	var directories = map[string]*CollectionDirectory{"": root}
+	coll.Visit(func(file RSCollectionFile) {
+		var path = collsplit(file.Name())
+		var dir = root
+		for i := range path[:len(path)-1] {
+			var dirPath = colljoin(dir.Path, path[i])
+			var sub = directories[dirPath]
+			if sub == nil {
+				sub = &CollectionDirectory{Name: path[i], Path: dirPath}
+				directories[dirPath] = sub
+				dir.Directories = append(dir.Directories, sub)
+			}
+			dir = sub
+		}
+		dir.Files = append(dir.Files, file)
+	})
+	root.count()
+	root.Sort(OrderByName)
+	return root
+}
+
+func (dir *CollectionDirectory) count() {
+	dir.FileCount = len(dir.Files)
+	dir.TotalSize = 0
+	dir.NewestModTime = time.Time{}
+	for _, file := range dir.Files {
+		dir.add(file.Size(), file.Timestamp())
+	}
+	for _, sub := range dir.Directories {
+		sub.count()
+		dir.FileCount += sub.FileCount
+		dir.add(sub.TotalSize, sub.NewestModTime)
+	}
+}
+
+func (dir *CollectionDirectory) add(size int64, timestamp time.Time) {
+	dir.TotalSize += size
+	if timestamp.After(dir.NewestModTime) {
+		dir.NewestModTime = timestamp
+	}
+}
+
+// Sorts files and subdirectories of dir and all its subdirectories, ties
+// are sorted by name
+func (dir *CollectionDirectory) Sort(order TreeOrder) {
+	sort.Slice(dir.Files, func(i, j int) bool {
+		var a, b = dir.Files[i], dir.Files[j]
+		return less(order, a.Name(), b.Name(), a.Size(), b.Size(), a.Timestamp(), b.Timestamp())
+	})
+	sort.Slice(dir.Directories, func(i, j int) bool {
+		var a, b = dir.Directories[i], dir.Directories[j]
+		return less(order, a.Name, b.Name, a.TotalSize, b.TotalSize, a.NewestModTime, b.NewestModTime)
+	})
+	for _, sub := range dir.Directories {
+		sub.Sort(order)
+	}
+}
+
+func less(order TreeOrder, nameA, nameB string, sizeA, sizeB int64, timeA, timeB time.Time) bool {
+	switch {
+	case order == OrderBySize && sizeA != sizeB:
+		return sizeA > sizeB
+	case order == OrderByTime && !timeA.Equal(timeB):
+		return timeA.After(timeB)
+	}
+	return nameA < nameB
+}
@@ -0,0 +1,54 @@
+package tallylib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CollectionTree_will_nest_directories(t *testing.T) {
+	var coll = NewCollection()
+	var err = coll.LoadFrom(strings.NewReader(`<!DOCTYPE RsCollection>
+<RsCollection>
+	<Directory name="Artist">
+		<Directory name="Album">
+			<File sha1="sha1a" name="track2" size="300" updated="2021-06-01T00:00:00Z"/>
+			<File sha1="sha1b" name="track1" size="100" updated="2022-06-01T00:00:00Z"/>
+		</Directory>
+		<File sha1="sha1c" name="cover.jpg" size="50"/>
+	</Directory>
+	<File sha1="sha1d" name="readme" size="1000" updated="2020-06-01T00:00:00Z"/>
+</RsCollection>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var root = CollectionTree(coll)
+	assertIntEquals(t, "files", 4, root.FileCount)
+	assertIntEquals(t, "size", 1450, int(root.TotalSize))
+	assertIntEquals(t, "newest", 2022, root.NewestModTime.Year())
+	assertIntEquals(t, "root files", 1, len(root.Files))
+	assertIntEquals(t, "root directories", 1, len(root.Directories))
+
+	var artist = root.Directories[0]
+	assertStringEquals(t, "Artist", artist.Name)
+	assertIntEquals(t, "artist files", 3, artist.FileCount)
+	assertIntEquals(t, "artist size", 450, int(artist.TotalSize))
+	var album = artist.Directories[0]
+	assertStringEquals(t, "Artist/Album", album.Path)
+	assertStringEquals(t, "Artist/Album/track1", album.Files[0].Name())
+	assertStringEquals(t, "Artist/Album/track2", album.Files[1].Name())
+
+	root.Sort(OrderBySize)
+	assertStringEquals(t, "Artist/Album/track2", album.Files[0].Name())
+	root.Sort(OrderByTime)
+	assertStringEquals(t, "Artist/Album/track1", album.Files[0].Name())
+
+	var empty = NewCollection()
+	empty.InitEmpty()
+	root = CollectionTree(empty)
+	assertIntEquals(t, "empty", 0, root.FileCount)
+	if root.NewestModTime != (time.Time{}) {
+		t.Error("empty collection has no timestamps")
+	}
+}
@@ -1,10 +1,12 @@
 package tallylib
 
 import (
+	"context"
 	"encoding/hex"
 	"crypto/sha1"
 	"io"
 	"os"
+	"time"
 )
 
 func updateFile(coll RSCollection, name string, path string, force bool) (bool, error) {
@@ -13,32 +15,113 @@ func updateFile(coll RSCollection, name string, path string, force bool) (bool,
 		existing = coll.ByName(name)
 	}
 
+	var updated, err = hashFileIfChanged(context.Background(), noProgress{}, nil, name, path, existing)
+	if updated != nil {
+		coll.UpdateFile(updated)
+		return true, nil
+	}
+
+	return false, err
+}
+
+// Returns new collection record for the file or nil if file is the same
+// as existing one. Does not modify any collection, so it is safe to call
+// from multiple goroutines. Hashing stops with ctx.Err() once ctx is done.
+// File is not read if cache (may be nil) knows its sha1
+func hashFileIfChanged(
+	ctx context.Context,
+	listener ProgressListener,
+	cache *hashCache,
+	name string,
+	path string,
+	existing RSCollectionFile) (RSCollectionFile, error) {
+
 	var stat, err = os.Stat(path)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if shouldUpdate(stat, existing) {
-		var digest = sha1.New()
-
-		var file *os.File
-		file, err = os.Open(path)
-		defer file.Close()
+	if !shouldUpdate(stat, existing) {
+		cache.store(path, stat, existing.Sha1())
+		listener.HashFinished(path, stat.Size(), 0)
+		return nil, nil
+	}
 
-		if _, err = io.Copy(digest, file); err != nil {
-			return false, err
+	var sha1sum, cached = cache.lookup(path, stat)
+	if cached {
+		listener.HashFinished(path, stat.Size(), 0)
+	} else {
+		listener.HashStarted(path, stat.Size())
+		var bytesRead int64
+		sha1sum, bytesRead, err = hashContents(ctx, listener, path)
+		listener.HashFinished(path, stat.Size(), bytesRead)
+		if err != nil {
+			return nil, err
 		}
+		cache.store(path, stat, sha1sum)
+	}
 
-		var sha1sum = hex.EncodeToString(digest.Sum(nil))
-		if existing == nil || existing.Sha1() != sha1sum {
-			coll.Update(name, sha1sum, stat.Size(), stat.ModTime())
-			return true, nil
-		}
+	if existing == nil || existing.Sha1() != sha1sum {
+		return newFile(name, sha1sum, stat.Size(), stat.ModTime()), nil
+	}
+	return nil, nil
+}
+
+// Returns sha1 of file contents and number of bytes read
+func hashContents(ctx context.Context, listener ProgressListener, path string) (string, int64, error) {
+	var file, err = os.Open(path)
+	if err != nil {
+		return "", 0, err
 	}
+	defer file.Close()
 
-	return false, nil
+	var digest = sha1.New()
+	var reader = &hashReader{ctx: ctx, in: file, listener: listener, path: path}
+	if _, err = io.Copy(digest, reader); err != nil {
+		return "", reader.bytesRead, err
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), reader.bytesRead, nil
+}
+
+// How often hashReader reports progress
+const hashProgressInterval = 4 << 20
+
+// Reader that fails as soon as context is done and reports progress
+type hashReader struct {
+	ctx       context.Context
+	in        io.Reader
+	listener  ProgressListener
+	path      string
+	bytesRead int64
+	reported  int64
+}
+
+func (reader *hashReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	var n, err = reader.in.Read(p)
+	reader.bytesRead += int64(n)
+	if reader.bytesRead-reader.reported >= hashProgressInterval {
+		reader.reported = reader.bytesRead
+		reader.listener.HashProgress(reader.path, reader.bytesRead)
+	}
+	return n, err
 }
 
 func shouldUpdate(stat os.FileInfo, existing RSCollectionFile) bool {
-	return existing == nil || stat.Size() != existing.Size() || stat.ModTime() != existing.Timestamp()
+	// time.Equal, since timestamps loaded from collection have different
+	// location than ones returned by os.Stat
+	return existing == nil || stat.Size() != existing.Size() || !stat.ModTime().Equal(existing.Timestamp())
+}
+
+// Same as hashFileIfChanged, but for contents that are already in memory
+func hashDataIfChanged(name string, data []byte, timestamp time.Time, existing RSCollectionFile) RSCollectionFile {
+	var sum = sha1.Sum(data)
+	var sha1sum = hex.EncodeToString(sum[:])
+	if existing == nil || existing.Sha1() != sha1sum {
+		return newFile(name, sha1sum, int64(len(data)), timestamp)
+	}
+	return nil
 }
@@ -1,9 +1,12 @@
 package tallylib
 
 import (
+	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestUpdateFile_file_does_not_exist(t *testing.T) {
@@ -84,3 +87,63 @@ func TestUpdate_existingFile(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestHashFileIfChanged_cancelled(t *testing.T) {
+	var temp, err = ioutil.TempFile("", "TestHashFileIfChanged_cancelled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path = temp.Name()
+	defer os.Remove(path)
+	temp.WriteString("hello")
+	temp.Close()
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	var updated RSCollectionFile
+	updated, err = hashFileIfChanged(ctx, noProgress{}, nil, "name", path, nil)
+	if err != context.Canceled {
+		t.Log("Should fail with context.Canceled, but got", err)
+		t.Fail()
+	}
+	if updated != nil {
+		t.Log("Should not return a record when cancelled")
+		t.Fail()
+	}
+}
+
+func TestShouldUpdate_timestamp_loaded_from_collection(t *testing.T) {
+	var temp, err = ioutil.TempFile("", "TestShouldUpdate_timestamp_loaded_from_collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path = temp.Name()
+	defer os.Remove(path)
+	temp.WriteString("hello")
+	temp.Close()
+	var stat os.FileInfo
+	stat, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var coll = NewCollection()
+	coll.InitEmpty()
+	coll.Update("name", "sha1", stat.Size(), stat.ModTime())
+	var stored bytes.Buffer
+	coll.StoreTo(&stored)
+	var loaded = NewCollection()
+	err = loaded.LoadFrom(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shouldUpdate(stat, loaded.ByName("name")) {
+		t.Log("Unchanged file should not be rehashed")
+		t.Fail()
+	}
+	if !shouldUpdate(stat, newFile("name", "sha1", stat.Size(), stat.ModTime().Add(time.Second))) {
+		t.Log("File with different timestamp should be rehashed")
+		t.Fail()
+	}
+}
@@ -1,7 +1,9 @@
 package tallylib
 
 import (
+	"context"
 	"io"
+	"time"
 )
 
 // Facade interface for the library
@@ -31,10 +33,102 @@ type Tally interface {
 	// * UpdateRecursive(directory, 0, 0) is same as 
         //   UpdateSingleDirectory(directory, true)
         // * UpdateRecursive(directory, 0, -1) always recurse to bottom
+	//
+	// .tally.conf overrides dig limits for a subtree, see dirConfig
 	UpdateRecursive(directory string, minDig, maxDig int) (bool, error)
 
+	// Same as UpdateSingleDirectory, but stops as soon as ctx is done,
+	// including in the middle of hashing a file, and returns ctx.Err().
+	// The collection being updated at that moment is written with files
+	// hashed so far, all other entries keep their previous values.
+	UpdateSingleDirectoryContext(ctx context.Context, directory string, addChildren bool) (bool, error)
+
+	// Same as UpdateRecursive, but stops as soon as ctx is done, see
+	// UpdateSingleDirectoryContext. Collections written before
+	// cancellation stay as they are, parents are not updated.
+	UpdateRecursiveContext(ctx context.Context, directory string, minDig, maxDig int) (bool, error)
+
 	// Where to log stuff, by default don't write anywhere
 	SetLog(log io.Writer)
+
+	// Returns collections created or rewritten by the last
+	// UpdateSingleDirectory or UpdateRecursive call. When DryRun=true,
+	// these are collections that would have been created or rewritten.
+	GetPlan() Plan
+
+	// Returns outcome of the last UpdateSingleDirectory or
+	// UpdateRecursive call, including the one which failed. Verify and
+	// Watch overwrite it too
+	GetResult() UpdateResult
+
+	// Where to report progress, by default (nil) don't report anything.
+	// When listener is set, every Update* call starts with a pre-scan
+	// of the tree to find out how many files it is going to check.
+	SetProgressListener(listener ProgressListener)
+
+	// Loads every collection in the tree and rehashes every file they
+	// reference regardless of its size and timestamp. Files which sha1
+	// does not match, which are missing or cannot be read are reported.
+	// Nothing is modified. Error is returned only if the tree itself
+	// cannot be walked.
+	Verify(directory string) (VerifyReport, error)
+
+	// Same as Verify, but stops as soon as ctx is done and returns what
+	// was verified so far along with ctx.Err()
+	VerifyContext(ctx context.Context, directory string) (VerifyReport, error)
+
+	// Lists entries of every collection in the tree (including parts of
+	// split ones) as files they refer to, with paths relative to
+	// directory and sorted. Entries outside of collection root path are
+	// skipped. Nothing is hashed or modified. Write the result with
+	// WriteExport.
+	Export(directory string) ([]ExportEntry, error)
+
+	// Same as Export, but stops as soon as ctx is done
+	ExportContext(ctx context.Context, directory string) ([]ExportEntry, error)
+
+	// Keeps collection tree of directory up to date until ctx is done.
+	// The whole tree is updated first, as UpdateRecursive(directory,
+	// minDig, maxDig) does, then file system changes are watched (Linux
+	// only). Once changes calm down for WatchDebounce, collections of
+	// changed directories are updated, followed by their parents. Same
+	// dig limits apply, so change below maxDig updates collection of the
+	// directory at maxDig. If change notifications are lost, the whole
+	// tree is walked again. Errors updating collections are logged and
+	// watching goes on. Returns ctx.Err() when ctx is done.
+	Watch(ctx context.Context, directory string, minDig, maxDig int) error
+}
+
+// Receives structured progress events from Tally. With HashWorkers > 1
+// hashing events come from several goroutines at once, so implementations
+// must be safe for concurrent use.
+type ProgressListener interface {
+	// Called once per Update* call before any other event with number
+	// of files and total bytes found by pre-scan. Counts are estimates:
+	// parent directories and collection files written during the update
+	// are not included.
+	ScanFinished(files int, bytes int64)
+
+	// Tally started listing files in a directory
+	DirectoryEntered(directory string)
+
+	// File content is about to be read
+	HashStarted(fullpath string, size int64)
+
+	// Called periodically while reading large files, bytesRead is total
+	// number of bytes read from the file so far
+	HashProgress(fullpath string, bytesRead int64)
+
+	// Called for every file checked (even if HashStarted was not called).
+	// bytesRead is 0 when file was not read because its size and
+	// timestamp did not change
+	HashFinished(fullpath string, size, bytesRead int64)
+
+	// Collection file was written to disk
+	CollectionWritten(collectionFile string)
+
+	// Parent directory collection was updated, see UpdateParents
+	ParentUpdated(directory string)
 }
 
 // Tally configuration
@@ -80,7 +174,11 @@ type TallyConfig struct {
 	// collection file name same as directory name. You can specify
 	// something more fancy, like for ex 
 	// "{{.Path(-1)}}-{{.Path(0)}}.rscollection", refer to 
-	// TallyPathNameEvalutationContext for available template functions
+	// TallyPathNameEvalutationContext for available template functions.
+	// Values can be transformed with lower, upper, title, replace,
+	// regexReplace, trim, truncate, join, default and slug, for ex
+	// "{{.Path 0 | replace \" \" \"_\" | lower}}.rscollection"
+	// Overridden for a subtree by .tally.conf, see dirConfig
 	CollectionPathnameExpression string
 
 	// This expression is evaluated using same framework ad 
@@ -92,7 +190,178 @@ type TallyConfig struct {
 	// evaluates to "Music/MP3", then file "Sepultura/1993/01.mp3"
 	// will be put into collection as "Music/MP3/Sepultura/1993/01.mp3".
 	// Note: the path separator is '/' regardless of OS.
+	// Overridden for a subtree by .tally.conf, see dirConfig
 	CollectionRootPathExpression string
+
+	// Walk the tree as usual, but do not write any .rscollection files.
+	// Use GetPlan() to find out what would have been changed.
+	DryRun bool
+
+	// Number of files hashed concurrently (1 by default). Collections are
+	// still written in the same order and with the same contents as with
+	// a single worker. Values above 1 pay off on SSDs and fast arrays,
+	// but may slow down hashing on a single spinning disk
+	HashWorkers int
+
+	// Number of previous versions of every .rscollection file to keep.
+	// 0 (default) keeps none, 1 keeps <name>.bak, N keeps <name>.bak
+	// (the newest), <name>.bak.2, ..., <name>.bak.N (the oldest).
+	// When enabled, backups are not added to parent collections.
+	CollectionBackups int
+
+	// Split collections with more entries than this into parts:
+	// Name.part1.rscollection, Name.part2.rscollection, ... are written
+	// instead of Name.rscollection. 0 (default) means no limit. Entries
+	// stay in their part across runs as long as it has room, new entries
+	// go to the first part with room. Parts left empty are removed, the
+	// rest keep their numbers, so peers do not download them again, and
+	// the gap is filled by new entries first. Name.rscollection is
+	// removed once collection is split
+	MaxCollectionEntries int
+
+	// Same as MaxCollectionEntries, for total size of files in collection
+	// in bytes. File bigger than this gets a part of its own
+	MaxCollectionSize int64
+
+	// gitignore-style patterns of files and directories to leave out of
+	// collections. Excluded directories are not descended into.
+	//   "*.part"     matches files and directories named *.part anywhere
+	//   ".git/"      trailing '/' matches directories only
+	//   "/tmp"       pattern containing '/' is matched against the path
+	//                relative to the directory passed to Update* call
+	//   "a/**/b"     '**' matches any number of directories
+	//   "!keep.part" '!' re-includes files excluded by earlier patterns
+	// The last matching pattern wins. Lines starting with '#' are ignored.
+	// More patterns, one per line, are read from .tallyignore file in
+	// any directory. They apply to that directory and everything below
+	// it, patterns with '/' are relative to the .tallyignore location.
+	// Patterns from deeper .tallyignore files take precedence over ones
+	// from upper directories, which take precedence over Exclude.
+	// .tallyignore files themselves are never put into collections.
+	// Exclude of .tally.conf (see dirConfig) works
+	// the same way, .tallyignore of the same directory wins over it.
+	Exclude []string
+
+	// When not empty, only files matching any of these patterns (same
+	// syntax as Exclude) are put into collections. Directories are still
+	// descended into and collection files of subdirectories are always
+	// included. Exclude takes precedence over Include.
+	Include []string
+
+	// Follow symbolic links to files and directories. By default links
+	// are skipped as non-regular files. Directory links leading back to
+	// a directory which is being processed (cycles) are skipped with a
+	// warning, so are links that cannot be resolved.
+	FollowSymlinks bool
+
+	// With FollowSymlinks, only follow links pointing inside the
+	// directory passed to Update* call
+	SymlinksWithinRoot bool
+
+	// Directory to keep hash cache in, one file per directory passed to
+	// Update* call. Empty (default) disables the cache. The cache
+	// remembers sha1 of every file along with its size, mtime and inode,
+	// so unchanged files are not rehashed even if collection has no
+	// usable timestamps for them, e.g. when collection was written by
+	// other software or was removed. ForceUpdate ignores the cache.
+	HashCacheDir string
+
+	// Write collections the way RetroShare exports them (see StoreStrict),
+	// without non-standard "updated" attribute. Collections written
+	// before it was turned on are rewritten by the next update even if
+	// no file has changed. Since collections then carry no timestamps, every file is
+	// rehashed on each run unless HashCacheDir is set
+	StrictRetroShareFormat bool
+
+	// Topmost directory .tally.conf and .tallyignore files are read from,
+	// files in directories above it are ignored. Set it when directories
+	// above the tree are not trusted. Empty (default) means files are
+	// read all the way up to the filesystem root, so the same directory
+	// gets the same settings whichever directory Update* call is made with
+	SettingsBoundary string
+
+	// How long Watch waits for changes to calm down before updating
+	// collections (2 seconds by default). Under constant changes
+	// collections are still updated every 10 such intervals.
+	WatchDebounce time.Duration
+
+	// When UpdateRecursive fails to update a subdirectory, skip just
+	// that subtree and go on with the rest. Skipped subtrees keep their
+	// old collections. Once everything else is done, *MultiError listing
+	// every failure is returned. By default the first failure stops the
+	// whole update.
+	ContinueOnError bool
+}
+
+// List of .rscollection files changed (or, in DryRun mode, to be changed)
+// by single UpdateSingleDirectory or UpdateRecursive call
+type Plan struct {
+	Collections []PlannedCollection
+}
+
+// Outcome of single UpdateSingleDirectory or UpdateRecursive call
+type UpdateResult struct {
+	// Collections written (or, with DryRun, to be written) and changes
+	// made to them, same as GetPlan().Collections
+	Collections []PlannedCollection
+
+	FilesHashed int   // files which contents had to be read, even if failed
+	BytesRead   int64 // total bytes read while hashing
+
+	// Problems which did not stop the update since IgnoreWarnings=true,
+	// *AccessError for every file which could not be hashed or
+	// collection which could not be loaded
+	Warnings []error
+
+	Elapsed time.Duration
+}
+
+// Changes made to single .rscollection file. All entry names are sorted.
+type PlannedCollection struct {
+	CollectionFile string   // path to .rscollection file
+	Created        bool     // true if collection file did not exist before
+	Deleted        bool     // collection file is removed, see MaxCollectionEntries
+	Added          []string // entries not present in old collection
+	Removed        []string // entries not present in new collection
+	Rehashed       []string // entries which sha1 has changed
+}
+
+// Outcome of Verify call
+type VerifyReport struct {
+	Collections int             // number of collections checked
+	Files       int             // number of files rehashed
+	Problems    []VerifyProblem // in the order found
+}
+
+type VerifyProblemKind int
+
+const (
+	VerifyMismatch   VerifyProblemKind = iota // sha1 of file is different
+	VerifyMissing                             // file does not exist
+	VerifyUnreadable                          // file or collection cannot be read
+)
+
+func (kind VerifyProblemKind) String() string {
+	switch kind {
+	case VerifyMismatch:
+		return "mismatch"
+	case VerifyMissing:
+		return "missing"
+	case VerifyUnreadable:
+		return "unreadable"
+	}
+	return "unknown"
+}
+
+// Single problem found by Verify
+type VerifyProblem struct {
+	Kind           VerifyProblemKind
+	CollectionFile string // collection the entry comes from
+	Name           string // entry name, empty if collection itself is unreadable
+	Fullpath       string // file entry refers to, empty if it is outside of collection root path
+	Expected       string // sha1 recorded in collection
+	Actual         string // sha1 of file on disk, for VerifyMismatch
+	Err            error  // for VerifyMissing and VerifyUnreadable
 }
 
 // When resolving collection name (see TallyConfig.CollectionPathnameExpression)
@@ -111,24 +380,33 @@ type TallyPathNameEvalutationContext interface {
 	//    Path(-4) returns ""
 	//    Path(1)  returns ""
 	Path(idx int) string
-}
 
-type AccessError struct {
-	fullpath string // path to file
-	message  string // additional message
-	cause    error  // underlying error, if any
-}
+	// Depth of directory below the directory Update* was called with:
+	// 0 for that directory itself, 1 for its subdirectories and so on.
+	// Parents updated because of TallyConfig.UpdateParents have negative
+	// depth
+	Depth() int
 
-func (e *AccessError) Error() string {
-	return e.fullpath + " " + e.message + " " + e.cause.Error()
-}
+	// Directory path relative to the directory Update* was called with,
+	// separated by '/'. Empty for that directory itself, "..", "../.."
+	// and so on for its parents
+	RelativePath() string
 
-type ExpressionError struct {
-	expression string // Expression caused error
-	message    string // Error message
-	cause      error  // underlying error, if any
-}
+	// Name of the directory Update* was called with
+	RootName() string
+
+	// Number of files in directory and all its subdirectories, not
+	// counting excluded files and collections of subdirectories. Names
+	// using it (or TotalSize, NewestModTime) change along with directory
+	// contents, collection written under the previous name is removed
+	// when directory is updated
+	FileCount() int
+
+	// Total size of files counted by FileCount, see also humanSize
+	// template function
+	TotalSize() int64
 
-func (e *ExpressionError) Error() string {
-	return e.expression + ": " + e.message + " " + e.cause.Error()
+	// The latest modification time of files counted by FileCount, zero
+	// if there are none
+	NewestModTime() time.Time
 }
@@ -0,0 +1,190 @@
+package tallylib
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/template"
+)
+
+// Name of per-directory file overriding TallyConfig for its subtree
+const dirConfigFileName = ".tally.conf"
+
+// Contents of .tally.conf, JSON. .tally.conf file in any directory
+// overrides TallyConfig for that directory and everything below it:
+//
+//   {
+//     "CollectionPathnameExpression": "{{.Path -1}}-{{.Path 0}}.rscollection",
+//     "CollectionRootPathExpression": "{{.Path 0}}",
+//     "MinDig": 1,
+//     "MaxDig": 2,
+//     "Exclude": ["*.m3u"]
+//   }
+//
+// Expressions which are not given are inherited from parent directories,
+// all the way up to TallyConfig.SettingsBoundary, whichever directory
+// Update* call is made with. Exclude patterns add to those of parents and
+// work like patterns of .tallyignore in the same directory, which take
+// precedence over them.
+//
+// Dig limits are a separate matter: depth counting starts over at the
+// directory .tally.conf is in, as if UpdateRecursive was called on it with
+// these limits. They only apply to directories UpdateRecursive walks
+// separately (not those put into a collection as a whole because of
+// MaxDig), so .tally.conf files above the directory UpdateRecursive is
+// called with do not limit it. If only one of them is given, the other one
+// is default.
+//
+// .tally.conf files are never put into collections.
+type dirConfig struct {
+	CollectionPathnameExpression *string
+	CollectionRootPathExpression *string
+	MinDig                       *int
+	MaxDig                       *int
+	Exclude                      []string
+}
+
+// Settings in effect for a directory
+type dirSettings struct {
+	pathnameExpression string
+	pathnameTemplate   *template.Template
+	rootPathTemplate   *template.Template
+	own                *dirConfig // .tally.conf of this very directory, if any
+}
+
+// Returns settings for directory: TallyConfig overridden by .tally.conf
+// files of directory and all its parents up to
+// TallyConfig.SettingsBoundary, deeper files take precedence. So settings
+// do not depend on directory Update* call was made with
+func (tally *tally) settingsFor(directory string) (*dirSettings, error) {
+	var abs, err = filepath.Abs(directory)
+	if err != nil {
+		return nil, tally.accessError(directory, "Cannot resolve absolute pathname", err)
+	}
+	return tally.settingsForAbs(abs)
+}
+
+func (tally *tally) settingsForAbs(directory string) (*dirSettings, error) {
+	var ret, found = tally.dirSettings[directory]
+	if found {
+		return ret, nil
+	}
+
+	var inherited = &dirSettings{
+		pathnameExpression: tally.config.CollectionPathnameExpression,
+		pathnameTemplate:   tally.collectionPathnameTemplate,
+		rootPathTemplate:   tally.collectionRootPathTemplate,
+	}
+	var err error
+	var parent = filepath.Dir(directory)
+	if parent != directory && tally.readsSettings(parent) {
+		inherited, err = tally.settingsForAbs(parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var conf *dirConfig
+	if tally.readsSettings(directory) {
+		conf, err = tally.loadDirConfig(directory)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ret, err = tally.applyDirConfig(inherited, conf)
+	if err != nil {
+		return nil, err
+	}
+	if tally.dirSettings != nil {
+		tally.dirSettings[directory] = ret
+	}
+	return ret, nil
+}
+
+func (tally *tally) loadDirConfig(directory string) (*dirConfig, error) {
+	var path = filepath.Join(directory, dirConfigFileName)
+	var data, err = ioutil.ReadFile(path)
+	// Path which is not a directory is reported elsewhere
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, nil
+	}
+	// So is directory which cannot be listed
+	if os.IsPermission(err) && !canList(directory) {
+		tally.debug("Cannot list", directory, "ignoring", path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, tally.accessError(path, "Cannot read", err)
+	}
+	var ret = new(dirConfig)
+	err = json.Unmarshal(data, ret)
+	if err != nil {
+		return nil, tally.kindError(ErrConfigParse, path, "Cannot parse", err)
+	}
+	tally.debug("Loaded settings from", path)
+	return ret, nil
+}
+
+// Returns true if .tally.conf and .tallyignore files of directory are
+// used, see TallyConfig.SettingsBoundary
+func (tally *tally) readsSettings(directory string) bool {
+	if tally.boundary == "" {
+		return true
+	}
+	var rel, err = filepath.Rel(tally.boundary, directory)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func canList(directory string) bool {
+	var dir, err = os.Open(directory)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	_, err = dir.Readdirnames(1)
+	return err == nil || err == io.EOF
+}
+
+func (tally *tally) applyDirConfig(inherited *dirSettings, conf *dirConfig) (*dirSettings, error) {
+	var ret = new(dirSettings)
+	*ret = *inherited
+	ret.own = conf
+	if conf == nil {
+		return ret, nil
+	}
+	var err error
+	if conf.CollectionPathnameExpression != nil {
+		ret.pathnameExpression = *conf.CollectionPathnameExpression
+		ret.pathnameTemplate, err = tally.compileTemplate(ret.pathnameExpression)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.CollectionRootPathExpression != nil {
+		ret.rootPathTemplate, err = tally.compileTemplate(*conf.CollectionRootPathExpression)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Dig limits of .tally.conf, see dirConfig
+func (conf *dirConfig) digLimits() (minDig, maxDig int, found bool) {
+	minDig, maxDig = 0, -1
+	if conf == nil || (conf.MinDig == nil && conf.MaxDig == nil) {
+		return minDig, maxDig, false
+	}
+	if conf.MinDig != nil {
+		minDig = *conf.MinDig
+	}
+	if conf.MaxDig != nil {
+		maxDig = *conf.MaxDig
+	}
+	return minDig, maxDig, true
+}
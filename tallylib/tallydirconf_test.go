@@ -0,0 +1,121 @@
+package tallylib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_UpdateRecursive_will_honor_tally_conf(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_honor_tally_conf")
+	defer os.RemoveAll(tmpdir)
+	share := mkdir(tmpdir, "share")
+	writefile(share, "file0", "Hello, world!")
+	music := mkdir(share, "music")
+	writefile(music, dirConfigFileName, `{
+		"CollectionPathnameExpression": "{{.Path -1}}-{{.Path 0}}.rscollection",
+		"MaxDig": 1,
+		"Exclude": ["*.m3u"]
+	}`)
+	artist := mkdir(music, "Artist")
+	writefile(artist, "list.m3u", "playlist")
+	album := mkdir(artist, "Album")
+	writefile(album, "track1", "Hello, world!")
+	books := mkdir(share, "books")
+	author := mkdir(books, "Author")
+	writefile(author, "book1", "Hello, world!")
+
+	fixture := createFixture()
+	var _, err = fixture.UpdateRecursive(share, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var coll = loadCollection(t, filepath.Join(music, "music-Artist.rscollection"))
+	assertFileInCollection(t, coll, "Album/track1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertCollectionSize(t, 1, coll)
+	assertPathNotExists(t, filepath.Join(artist, "Artist-Album.rscollection"))
+
+	coll = loadCollection(t, filepath.Join(share, "share-music.rscollection"))
+	assertFileInCollection(t, coll, "music-Artist.rscollection", "")
+	assertCollectionSize(t, 1, coll)
+
+	coll = loadCollection(t, filepath.Join(books, "Author.rscollection"))
+	assertFileInCollection(t, coll, "book1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	coll = loadCollection(t, filepath.Join(tmpdir, "share.rscollection"))
+	assertFileInCollection(t, coll, "file0", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertFileInCollection(t, coll, "books.rscollection", "")
+	assertFileInCollection(t, coll, "share-music.rscollection", "")
+	assertCollectionSize(t, 3, coll)
+}
+
+func Test_UpdateRecursive_will_fail_on_invalid_tally_conf(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_fail_on_invalid_tally_conf")
+	defer os.RemoveAll(tmpdir)
+	subdir := mkdir(tmpdir, "subdir")
+	writefile(subdir, dirConfigFileName, `{"MaxDig": "deep"}`)
+
+	var _, err = createFixture().UpdateRecursive(subdir, 0, -1)
+	assertErrorKind(t, ErrConfigParse, err)
+}
+
+func Test_UpdateRecursive_will_honor_tally_conf_above_root(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_honor_tally_conf_above_root")
+	defer os.RemoveAll(tmpdir)
+	share := mkdir(tmpdir, "share")
+	writefile(share, dirConfigFileName, `{"CollectionPathnameExpression": "{{.Path -1}}-{{.Path 0}}.rscollection"}`)
+	music := mkdir(share, "music")
+	artist := mkdir(music, "Artist")
+	writefile(artist, "track1", "Hello, world!")
+
+	fixture := createFixture()
+	update(t, fixture, share, true)
+	var parent = filepath.Join(share, "share-music.rscollection")
+	var oldSha = loadCollection(t, parent).ByName("music-Artist.rscollection").Sha1()
+
+	writefile(artist, "track2", "Hello 2")
+	var config = fixture.GetConfig()
+	config.UpdateParents = true
+	fixture.SetConfig(config)
+	update(t, fixture, artist, true)
+	assertPathNotExists(t, filepath.Join(music, "Artist.rscollection"))
+	var coll = loadCollection(t, filepath.Join(music, "music-Artist.rscollection"))
+	assertFileInCollection(t, coll, "track2", "")
+	assertCollectionSize(t, 2, coll)
+	if loadCollection(t, parent).ByName("music-Artist.rscollection").Sha1() == oldSha {
+		t.Error("parent collection was not updated")
+	}
+}
+
+func Test_UpdateRecursive_will_ignore_tally_conf_above_SettingsBoundary(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_ignore_tally_conf_above_SettingsBoundary")
+	defer os.RemoveAll(tmpdir)
+	writefile(tmpdir, dirConfigFileName, `{"CollectionPathnameExpression": "HIJACK.rscollection"}`)
+	share := mkdir(tmpdir, "share")
+	music := mkdir(share, "music")
+	writefile(music, "file1", "Hello, world!")
+
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.SettingsBoundary = share
+	fixture.SetConfig(config)
+	assertUpdateRecursive(t, fixture, music)
+	assertPathNotExists(t, filepath.Join(share, "HIJACK.rscollection"))
+	assertFileInCollection(t, loadCollection(t, filepath.Join(share, "music.rscollection")), "file1", "")
+}
+
+func Test_UpdateRecursive_will_report_unlistable_directory(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_report_unlistable_directory")
+	defer os.RemoveAll(tmpdir)
+	noAccess := mkdir(tmpdir, "noaccess")
+	os.Chmod(noAccess, 0)
+	defer os.Chmod(noAccess, 0755)
+
+	var _, err = createFixture().UpdateRecursive(noAccess, 0, -1)
+	var accessErr *AccessError
+	if !errors.As(err, &accessErr) || accessErr.Message() != "Can't list" {
+		t.Error("expected listing error, got", err)
+	}
+	assertErrorKind(t, ErrPermission, err)
+}
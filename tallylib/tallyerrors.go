@@ -0,0 +1,156 @@
+package tallylib
+
+import (
+	"errors"
+	"io/fs"
+	"strconv"
+)
+
+// Kinds of errors returned by Tally, test for them with errors.Is:
+//
+//	if errors.Is(err, tallylib.ErrNotDirectory) { ... }
+//
+// ErrNotFound and ErrPermission are the same values as fs.ErrNotExist and
+// fs.ErrPermission, so they also match underlying os errors. Error may
+// match several kinds, for example collection which cannot be written
+// because of permissions is both ErrWrite and ErrPermission
+var (
+	ErrNotFound        = fs.ErrNotExist
+	ErrPermission      = fs.ErrPermission
+	ErrNotDirectory    = errors.New("not a directory")
+	ErrCollectionParse = errors.New("cannot parse collection")
+	ErrTemplate        = errors.New("invalid template")
+	ErrPattern         = errors.New("invalid pattern")
+	ErrWrite           = errors.New("cannot write")
+	ErrConfigParse     = errors.New("cannot parse configuration")
+	ErrMergeConflict   = errors.New("conflicting entries")
+)
+
+// In the order Kind() checks them, most specific first
+var errorKinds = []error{
+	ErrNotDirectory,
+	ErrCollectionParse,
+	ErrTemplate,
+	ErrPattern,
+	ErrWrite,
+	ErrConfigParse,
+	ErrMergeConflict,
+	ErrNotFound,
+	ErrPermission,
+}
+
+func kindOf(err error) error {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// Problem with particular file or directory
+type AccessError struct {
+	fullpath string // path to file
+	message  string // additional message
+	kind     error  // one of Err* values, if known
+	cause    error  // underlying error, if any
+}
+
+func newAccessError(kind error, fullpath, message string, cause error) *AccessError {
+	return &AccessError{fullpath: fullpath, message: message, kind: kind, cause: cause}
+}
+
+func (e *AccessError) Error() string {
+	var ret = e.fullpath + " " + e.message
+	if e.cause != nil {
+		ret += " " + e.cause.Error()
+	}
+	return ret
+}
+
+// File or directory the error is about
+func (e *AccessError) Path() string {
+	return e.fullpath
+}
+
+// Human readable description, without path and cause
+func (e *AccessError) Message() string {
+	return e.message
+}
+
+// Returns the most specific Err* value this error matches, nil if none
+func (e *AccessError) Kind() error {
+	return kindOf(e)
+}
+
+func (e *AccessError) Is(target error) bool {
+	return e.kind != nil && e.kind == target
+}
+
+// Returns underlying error, nil if there is none
+func (e *AccessError) Unwrap() error {
+	return e.cause
+}
+
+// Invalid template or pattern
+type ExpressionError struct {
+	expression string // Expression caused error
+	message    string // Error message
+	kind       error  // ErrTemplate or ErrPattern
+	cause      error  // underlying error, if any
+}
+
+func newExpressionError(kind error, expression, message string, cause error) *ExpressionError {
+	return &ExpressionError{expression: expression, message: message, kind: kind, cause: cause}
+}
+
+func (e *ExpressionError) Error() string {
+	var ret = e.expression + ": " + e.message
+	if e.cause != nil {
+		ret += " " + e.cause.Error()
+	}
+	return ret
+}
+
+// Template or pattern text, empty if unknown
+func (e *ExpressionError) Expression() string {
+	return e.expression
+}
+
+// Human readable description, without expression and cause
+func (e *ExpressionError) Message() string {
+	return e.message
+}
+
+// Returns ErrTemplate or ErrPattern
+func (e *ExpressionError) Kind() error {
+	return kindOf(e)
+}
+
+func (e *ExpressionError) Is(target error) bool {
+	return e.kind != nil && e.kind == target
+}
+
+// Returns underlying error, nil if there is none
+func (e *ExpressionError) Unwrap() error {
+	return e.cause
+}
+
+// Returned by UpdateRecursive with ContinueOnError=true if any subtree
+// failed
+type MultiError struct {
+	Errors []error // *AccessError for every skipped directory, in order
+}
+
+func (e *MultiError) Error() string {
+	var ret = strconv.Itoa(len(e.Errors)) + " directories failed:"
+	for _, err := range e.Errors {
+		ret += "\n\t" + err.Error()
+	}
+	return ret
+}
+
+// Allows errors.Is and errors.As to look into every failure
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
@@ -0,0 +1,110 @@
+package tallylib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_errors_will_match_their_kind(t *testing.T) {
+	tmpdir := mktmp("Test_errors_will_match_their_kind")
+	defer os.RemoveAll(tmpdir)
+	subdir := mkdir(tmpdir, "subdir")
+	file := writefile(subdir, "file1", "Hello, world!")
+
+	var err = updateWith(t, func(config *TallyConfig) {}, filepath.Join(tmpdir, "missing"))
+	assertErrorKind(t, ErrNotFound, err)
+
+	err = updateWith(t, func(config *TallyConfig) {}, file)
+	assertErrorKind(t, ErrNotDirectory, err)
+
+	err = updateWith(t, func(config *TallyConfig) {
+		config.CollectionPathnameExpression = "{{.Iinvalid call}}"
+	}, subdir)
+	assertErrorKind(t, ErrTemplate, err)
+
+	err = updateWith(t, func(config *TallyConfig) {
+		config.Exclude = []string{"[invalid"}
+	}, subdir)
+	assertErrorKind(t, ErrPattern, err)
+
+	var collectionFile = writefile(tmpdir, "subdir.rscollection", "INVALID")
+	err = updateWith(t, func(config *TallyConfig) {}, subdir)
+	assertErrorKind(t, ErrCollectionParse, err)
+
+	os.Remove(collectionFile)
+	os.Chmod(tmpdir, 0555)
+	defer os.Chmod(tmpdir, 0755)
+	err = updateWith(t, func(config *TallyConfig) {}, subdir)
+	assertErrorKind(t, ErrWrite, err)
+	if !errors.Is(err, ErrPermission) {
+		t.Error("expected ErrPermission as well, got", err)
+	}
+	var accessErr *AccessError
+	if !errors.As(err, &accessErr) {
+		t.Fatal("expected *AccessError, got", err)
+	}
+	assertStringEquals(t, tmpdir, filepath.Dir(accessErr.Path()))
+	if !errors.Is(accessErr.Unwrap(), os.ErrPermission) {
+		t.Error("expected permission error as a cause, got", accessErr.Unwrap())
+	}
+}
+
+func Test_errors_without_cause_will_format(t *testing.T) {
+	var err = newAccessError(ErrNotDirectory, "/some/file", "supplied path is not a directory", nil)
+	assertStringEquals(t, "/some/file supplied path is not a directory", err.Error())
+	if err.Unwrap() != nil {
+		t.Error("expected no cause")
+	}
+
+	var exprErr = newExpressionError(ErrTemplate, "", "Evaluates to empty string", nil)
+	assertStringEquals(t, ": Evaluates to empty string", exprErr.Error())
+	if exprErr.Kind() != ErrTemplate {
+		t.Error("expected ErrTemplate, got", exprErr.Kind())
+	}
+}
+
+func Test_MultiError_will_match_kinds_of_failures(t *testing.T) {
+	var failure = newAccessError(nil, "/dir", "Skipped:", newAccessError(ErrCollectionParse, "/dir.rscollection", "Load error", errors.New("EOF")))
+	var err error = &MultiError{Errors: []error{failure}}
+	if !errors.Is(err, ErrCollectionParse) {
+		t.Error("expected ErrCollectionParse")
+	}
+	if errors.Is(err, ErrWrite) {
+		t.Error("unexpected ErrWrite")
+	}
+	if failure.Kind() != ErrCollectionParse {
+		t.Error("expected ErrCollectionParse, got", failure.Kind())
+	}
+}
+
+func updateWith(t *testing.T, configure func(config *TallyConfig), directory string) error {
+	var fixture = createFixture()
+	var config = fixture.GetConfig()
+	configure(&config)
+	fixture.SetConfig(config)
+	var _, err = fixture.UpdateSingleDirectory(directory, false)
+	if err == nil {
+		t.Fatal("expected error for", directory)
+	}
+	return err
+}
+
+func assertErrorKind(t *testing.T, expected, err error) {
+	if !errors.Is(err, expected) {
+		t.Error("expected", expected, "got", err)
+		return
+	}
+	var kind error
+	var accessErr *AccessError
+	var exprErr *ExpressionError
+	if errors.As(err, &accessErr) {
+		kind = accessErr.Kind()
+	} else if errors.As(err, &exprErr) {
+		kind = exprErr.Kind()
+	}
+	if kind != expected {
+		t.Error("expected kind", expected, "got", kind)
+	}
+}
@@ -0,0 +1,109 @@
+package tallylib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (tally *tally) Export(directory string) ([]ExportEntry, error) {
+	return tally.ExportContext(context.Background(), directory)
+}
+
+func (tally *tally) ExportContext(ctx context.Context, directory string) ([]ExportEntry, error) {
+	var entries = []ExportEntry{}
+	defer tally.end()
+	var err = tally.begin(ctx, directory)
+	if err != nil {
+		return entries, err
+	}
+
+	var normalizedPath = filepath.Clean(directory)
+	tally.info("Export(", normalizedPath, ")")
+	err = tally.assertDirectory(normalizedPath)
+	if err == nil {
+		err = tally.exportRecursive(normalizedPath, normalizedPath, &entries)
+	}
+	sortExportEntries(entries)
+	return entries, err
+}
+
+func (tally *tally) exportRecursive(top, directory string, entries *[]ExportEntry) error {
+	var err = tally.exportDirectory(top, directory, entries)
+	if err != nil {
+		return err
+	}
+
+	var files []os.FileInfo
+	files, err = tally.listDirectory(directory)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = tally.ctx.Err(); err != nil {
+			return err
+		}
+		if !tally.isDir(file) {
+			continue
+		}
+		var fullpath = filepath.Join(directory, file.Name())
+		if !tally.enterDirectory(fullpath, file) {
+			continue
+		}
+		err = tally.exportRecursive(top, fullpath, entries)
+		tally.leaveDirectory()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Appends entries of directory collection (and its parts) with paths
+// relative to top
+func (tally *tally) exportDirectory(top, directory string, entries *[]ExportEntry) error {
+	var collectionFile, err = tally.resolveCollectionFileForDirectory(directory)
+	if err != nil {
+		return err
+	}
+	var files = tally.collectionFiles(collectionFile)
+	if len(files) == 0 {
+		tally.debug("No collection", collectionFile, "to export")
+		return nil
+	}
+	var root string
+	root, err = tally.resolveCollectionRootPathForDirectory(directory)
+	if err != nil {
+		return err
+	}
+	var prefix string
+	prefix, err = filepath.Rel(top, directory)
+	if err != nil {
+		return err
+	}
+	prefix = filepath.ToSlash(prefix)
+	if prefix == "." {
+		prefix = ""
+	}
+
+	for _, file := range files {
+		var coll RSCollection
+		coll, err = tally.loadExistingCollection(file)
+		if err != nil {
+			return err
+		}
+		coll.Visit(func(entry RSCollectionFile) {
+			var rel = entry.Name()
+			if root != "" {
+				if !strings.HasPrefix(rel, root+"/") {
+					tally.warn("Export: skipping", rel, "in", file, "which is outside of", root)
+					return
+				}
+				rel = rel[len(root)+1:]
+			}
+			*entries = append(*entries, exportEntry(colljoin(prefix, rel), entry))
+		})
+	}
+	return nil
+}
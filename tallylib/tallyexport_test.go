@@ -0,0 +1,43 @@
+package tallylib
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_Export_will_list_files_of_tree(t *testing.T) {
+	tmpdir := mktmp("Test_Export_will_list_files_of_tree")
+	defer os.RemoveAll(tmpdir)
+	root := mkdir(tmpdir, "root")
+	writefile(root, "file0", "Hello, world!")
+	subdir := mkdir(root, "subdir")
+	writefile(subdir, "file1", "Hello, world!")
+	writefile(mkdir(subdir, "deep"), "file2", "Hello, world!")
+	mkdir(root, "empty")
+
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.CollectionRootPathExpression = "{{.Path 0}}"
+	fixture.SetConfig(config)
+	var _, err = fixture.UpdateRecursive(root, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fixture.Export(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	assertPlannedEntries(t, "exported", []string{
+		"file0",
+		"subdir.rscollection",
+		"subdir/deep/file2",
+		"subdir/file1",
+	}, paths)
+	assertStringEquals(t, "943a702d06f34599aee1f8da8ef9f7296031d699", entries[0].Sha1)
+	assertIntEquals(t, "size", 13, int(entries[0].Size))
+}
@@ -0,0 +1,202 @@
+package tallylib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Name of per-directory file with exclude patterns, see TallyConfig.Exclude
+const ignoreFileName = ".tallyignore"
+
+// Compiles TallyConfig.Exclude and TallyConfig.Include. Anchored patterns
+// are relative to root
+func (tally *tally) compilePatterns(root string) error {
+	var err error
+	tally.ignoreFiles = make(map[string]patternList)
+	tally.exclude, err = tally.compilePatternList(tally.config.Exclude, root)
+	if err != nil {
+		return err
+	}
+	tally.include, err = tally.compilePatternList(tally.config.Include, root)
+	return err
+}
+
+// Returns exclude patterns in effect for entries of directory: global ones
+// followed by patterns from .tally.conf and .tallyignore files of directory
+// and all its parents up to TallyConfig.SettingsBoundary, top to bottom, so
+// deeper files take precedence
+func (tally *tally) excludesFor(directory string) (patternList, error) {
+	var abs, err = filepath.Abs(directory)
+	if err != nil {
+		return nil, tally.accessError(directory, "Cannot resolve absolute pathname", err)
+	}
+	return tally.excludesForAbs(abs)
+}
+
+func (tally *tally) excludesForAbs(directory string) (patternList, error) {
+	var ret, found = tally.ignoreFiles[directory]
+	if found {
+		return ret, nil
+	}
+
+	var err error
+	var parent = filepath.Dir(directory)
+	if parent != directory && tally.readsSettings(parent) {
+		ret, err = tally.excludesForAbs(parent)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ret = tally.exclude
+	}
+
+	var own patternList
+	if tally.readsSettings(directory) {
+		own, err = tally.loadIgnoreFile(directory)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var settings *dirSettings
+	settings, err = tally.settingsForAbs(directory)
+	if err != nil {
+		return nil, err
+	}
+	if settings.own != nil && len(settings.own.Exclude) > 0 {
+		var confExcludes patternList
+		confExcludes, err = tally.compilePatternList(settings.own.Exclude, directory)
+		if err != nil {
+			return nil, err
+		}
+		// .tallyignore takes precedence, just like it does over TallyConfig
+		own = append(confExcludes, own...)
+	}
+	if len(own) > 0 {
+		var merged = make(patternList, 0, len(ret)+len(own))
+		merged = append(merged, ret...)
+		ret = append(merged, own...)
+	}
+	tally.ignoreFiles[directory] = ret
+	return ret, nil
+}
+
+func (tally *tally) loadIgnoreFile(directory string) (patternList, error) {
+	var path = filepath.Join(directory, ignoreFileName)
+	var data, err = ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, tally.accessError(path, "Cannot load patterns:", err)
+	}
+	tally.debug("Loaded patterns from", path)
+	return tally.compilePatternList(strings.Split(string(data), "\n"), directory)
+}
+
+func (tally *tally) compilePatternList(texts []string, base string) (patternList, error) {
+	var ret patternList
+	for _, text := range texts {
+		var pattern, err = compilePattern(text, base)
+		if err != nil {
+			var patternErr = newExpressionError(ErrPattern, text, "Invalid pattern", err)
+			tally.err(patternErr)
+			return nil, patternErr
+		}
+		if pattern != nil {
+			ret = append(ret, pattern)
+		}
+	}
+	return ret, nil
+}
+
+// Removes excluded entries from directory listing
+func (tally *tally) filterExcluded(directory string, files []os.FileInfo) ([]os.FileInfo, error) {
+	var excludes, err = tally.excludesFor(directory)
+	if err != nil {
+		return nil, err
+	}
+	if len(excludes) == 0 && len(tally.include) == 0 {
+		return files, nil
+	}
+	var collections map[string]bool
+	if len(tally.include) > 0 {
+		collections = tally.subdirectoryCollections(directory, files)
+	}
+
+	var ret = make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		var fullpath = filepath.Join(directory, file.Name())
+		if isExcluded(excludes, fullpath, tally.isDir(file)) {
+			tally.debug("Skipping", fullpath, "since it is excluded")
+		} else if tally.isFile(file) && !collections[fullpath] && !tally.isIncluded(fullpath) {
+			tally.debug("Skipping", fullpath, "since it is not included")
+		} else {
+			ret = append(ret, file)
+		}
+	}
+	return ret, nil
+}
+
+// Same as filterExcluded, but for a single file somewhere below directory.
+// Parent directories of the file are checked too. Problems reading
+// .tallyignore files are reported by filterExcluded, here they just mean
+// the file is not filtered out
+func (tally *tally) isFilteredOut(directory, fullpath string, stat os.FileInfo) bool {
+	var path = fullpath
+	var isDir = tally.isDir(stat)
+	for len(path) > len(directory) {
+		var excludes, err = tally.excludesFor(filepath.Dir(path))
+		if err == nil && isExcluded(excludes, path, isDir) {
+			return true
+		}
+		path = filepath.Dir(path)
+		isDir = true
+	}
+	return tally.isFile(stat) && !tally.isIncluded(fullpath)
+}
+
+func isExcluded(excludes patternList, fullpath string, isDir bool) bool {
+	var matched, negated = excludes.match(fullpath, isDir)
+	return matched && !negated
+}
+
+func (tally *tally) isIncluded(fullpath string) bool {
+	if len(tally.include) == 0 {
+		return true
+	}
+	var matched, negated = tally.include.match(fullpath, false)
+	return matched && !negated
+}
+
+// Collection files of subdirectories (and their parts) are never filtered
+// out by TallyConfig.Include, otherwise collection tree would fall apart
+func (tally *tally) subdirectoryCollections(directory string, files []os.FileInfo) map[string]bool {
+	var ret = tally.directoryCollections(directory, files)
+	var parts []string
+	for _, file := range files {
+		var fullpath = filepath.Join(directory, file.Name())
+		if whole, n := partOf(fullpath); n > 0 && ret[whole] {
+			parts = append(parts, fullpath)
+		}
+	}
+	for _, part := range parts {
+		ret[part] = true
+	}
+	return ret
+}
+
+// Returns collection files of subdirectories, without their parts
+func (tally *tally) directoryCollections(directory string, files []os.FileInfo) map[string]bool {
+	var ret = make(map[string]bool)
+	for _, file := range files {
+		if tally.isDir(file) {
+			var collectionFile, err = tally.resolveCollectionFileForDirectory(filepath.Join(directory, file.Name()))
+			if err == nil {
+				ret[filepath.Clean(collectionFile)] = true
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,115 @@
+package tallylib
+
+import (
+	"sync"
+)
+
+// Single file submitted for hashing
+type hashJob struct {
+	collpath string
+	fullpath string
+	existing RSCollectionFile // nil means always take new record
+	updated  RSCollectionFile // result, nil if file has not changed
+	err      error
+	done     chan struct{}
+}
+
+// Hashes files on TallyConfig.HashWorkers goroutines. Results are applied
+// to the collection by the submitting goroutine strictly in the order
+// files were submitted, so outcome does not depend on number of workers.
+type hashPool struct {
+	tally    *tally
+	coll     RSCollection
+	queue    chan *hashJob
+	inFlight []*hashJob
+	window   int
+	workers  sync.WaitGroup
+	changed  bool
+	err      error
+}
+
+func (tally *tally) startHashing(coll RSCollection) *hashPool {
+	var workers = tally.config.HashWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var pool = new(hashPool)
+	pool.tally = tally
+	pool.coll = coll
+	pool.queue = make(chan *hashJob)
+	pool.window = workers * 4
+	pool.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+func (pool *hashPool) work() {
+	defer pool.workers.Done()
+	for job := range pool.queue {
+		job.updated, job.err = hashFileIfChanged(pool.tally.ctx, pool.tally.progress, pool.tally.cache, job.collpath, job.fullpath, job.existing)
+		close(job.done)
+	}
+}
+
+// Queue file for hashing. May apply results of earlier files and return
+// their error
+func (pool *hashPool) submit(collpath, fullpath string, existing RSCollectionFile) error {
+	var job = new(hashJob)
+	job.collpath = collpath
+	job.fullpath = fullpath
+	job.existing = existing
+	job.done = make(chan struct{})
+
+	var pending = pool.tally.pending[fullpath]
+	if pending != nil {
+		pool.tally.debug("Using DryRun contents of", fullpath)
+		job.updated = hashDataIfChanged(collpath, pending.data, pending.timestamp, existing)
+		close(job.done)
+	} else {
+		pool.queue <- job
+	}
+	pool.inFlight = append(pool.inFlight, job)
+
+	if len(pool.inFlight) > pool.window {
+		return pool.applyNext()
+	}
+	return nil
+}
+
+// Waits until all submitted files are hashed and stops workers.
+// Returns true if collection has changed
+func (pool *hashPool) finish() (bool, error) {
+	close(pool.queue)
+	pool.workers.Wait()
+	for pool.err == nil && len(pool.inFlight) > 0 {
+		pool.applyNext()
+	}
+	return pool.changed, pool.err
+}
+
+func (pool *hashPool) applyNext() error {
+	var job = pool.inFlight[0]
+	pool.inFlight = pool.inFlight[1:]
+	<-job.done
+
+	var tally = pool.tally
+	if job.err != nil && tally.ctx.Err() != nil {
+		tally.debug("Cancelled while hashing", job.fullpath)
+	} else if job.err != nil {
+		// Failure to update single file is not critical
+		tally.warn("Could not update", job.fullpath, job.err)
+		if !tally.config.IgnoreWarnings {
+			tally.warn("Stopping on warning")
+			pool.err = job.err
+			return job.err
+		}
+		tally.skipWarning(nil, job.fullpath, "Could not update", job.err)
+	} else if job.updated != nil {
+		tally.info("Detected change in", job.collpath)
+		pool.coll.UpdateFile(job.updated)
+		pool.changed = true
+	}
+	return nil
+}
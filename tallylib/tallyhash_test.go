@@ -0,0 +1,65 @@
+package tallylib
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func Test_HashWorkers_produce_same_collection(t *testing.T) {
+	var tmpdir = mktmp("Test_HashWorkers_produce_same_collection")
+	defer os.RemoveAll(tmpdir)
+
+	var subdir = mkdir(tmpdir, "subdir")
+	var nested = mkdir(subdir, "nested")
+	for i := 0; i < 50; i++ {
+		writefile(subdir, fmt.Sprintf("file%d", i), fmt.Sprintf("Hello %d", i))
+		writefile(nested, fmt.Sprintf("file%d", i), fmt.Sprintf("Nested %d", i))
+	}
+
+	var sequential = setup_hashWorkers(1)
+	if changed, err := sequential.UpdateSingleDirectory(subdir, true); err != nil || !changed {
+		t.Fatal("Sequential update failed", changed, err)
+	}
+	var expected = loadCollectionForDirectory(t, subdir)
+	os.Remove(resolveCollectionFileSimple(subdir))
+
+	var parallel = setup_hashWorkers(8)
+	if changed, err := parallel.UpdateSingleDirectory(subdir, true); err != nil || !changed {
+		t.Fatal("Parallel update failed", changed, err)
+	}
+	var actual = loadCollectionForDirectory(t, subdir)
+
+	assertCollectionSize(t, expected.Size(), actual)
+	expected.Visit(func(file RSCollectionFile) {
+		assertFileInCollection(t, actual, file.Name(), file.Sha1())
+	})
+	assertWillNotUpdateSingleDirectory(t, parallel, subdir)
+}
+
+func Test_HashWorkers_will_fail_when_no_access_to_file(t *testing.T) {
+	var tmpdir = mktmp("Test_HashWorkers_will_fail_when_no_access_to_file")
+	defer os.RemoveAll(tmpdir)
+
+	var subdir = mkdir(tmpdir, "subdir")
+	for i := 0; i < 20; i++ {
+		writefile(subdir, fmt.Sprintf("file%d", i), fmt.Sprintf("Hello %d", i))
+	}
+	os.Chmod(writefile(subdir, "file5", "forbidden"), 0)
+
+	var fixture = setup_hashWorkers(4)
+	var _, err = fixture.UpdateSingleDirectory(subdir, false)
+	if err == nil {
+		t.Log("Should fail when input file has no read permssions")
+		t.Fail()
+	}
+	assertPathNotExists(t, resolveCollectionFileSimple(subdir))
+}
+
+func setup_hashWorkers(workers int) Tally {
+	var fixture = createFixture()
+	var config = fixture.GetConfig()
+	config.HashWorkers = workers
+	fixture.SetConfig(config)
+	return fixture
+}
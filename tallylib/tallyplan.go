@@ -0,0 +1,115 @@
+package tallylib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Collection that would have been written to disk if not in DryRun mode.
+// Kept in memory so parent directories see it exactly as if it was written
+type pendingCollection struct {
+	data      []byte
+	timestamp time.Time
+}
+
+// Simulates os.FileInfo for a pending collection that does not exist on
+// disk yet
+type pendingFileInfo struct {
+	name    string
+	pending *pendingCollection
+}
+
+func (info *pendingFileInfo) Name() string       { return info.name }
+func (info *pendingFileInfo) Size() int64        { return int64(len(info.pending.data)) }
+func (info *pendingFileInfo) Mode() os.FileMode  { return 0444 }
+func (info *pendingFileInfo) ModTime() time.Time { return info.pending.timestamp }
+func (info *pendingFileInfo) IsDir() bool        { return false }
+func (info *pendingFileInfo) Sys() interface{}   { return nil }
+
+func (tally *tally) resetPlan() {
+	tally.plan = Plan{}
+	tally.pending = make(map[string]*pendingCollection)
+	tally.removed = make(map[string]bool)
+}
+
+func (tally *tally) storePendingCollection(coll RSCollection, fileTo string) error {
+	var buf bytes.Buffer
+	var err = tally.storeCollection(coll, &buf)
+	if err != nil {
+		return tally.kindError(ErrWrite, fileTo, "Cannot save", err)
+	}
+	var pending = new(pendingCollection)
+	pending.data = buf.Bytes()
+	pending.timestamp = time.Now()
+	tally.pending[filepath.Clean(fileTo)] = pending
+	delete(tally.removed, filepath.Clean(fileTo))
+	tally.info("DryRun: not writing", fileTo)
+	return nil
+}
+
+// Adds pending collections which do not exist on disk to directory listing
+// and hides ones which would have been removed
+func (tally *tally) appendPendingFiles(directory string, files []os.FileInfo) []os.FileInfo {
+	if len(tally.pending) == 0 && len(tally.removed) == 0 {
+		return files
+	}
+	var dir = filepath.Clean(directory)
+	var existing = make(map[string]bool)
+	var kept = files[:0]
+	for _, file := range files {
+		if tally.removed[filepath.Join(dir, file.Name())] {
+			tally.debug("Hiding DryRun removed collection", file.Name())
+			continue
+		}
+		existing[file.Name()] = true
+		kept = append(kept, file)
+	}
+	files = kept
+	for path, pending := range tally.pending {
+		var name = filepath.Base(path)
+		if filepath.Dir(path) == dir && !existing[name] {
+			tally.debug("Adding DryRun collection", name, "to listing")
+			files = append(files, &pendingFileInfo{name, pending})
+		}
+	}
+	return files
+}
+
+func (tally *tally) recordPlan(collectionFile string, before map[string]string, newColl RSCollection) {
+	var planned PlannedCollection
+	planned.CollectionFile = collectionFile
+	var _, err = os.Stat(collectionFile)
+	planned.Created = os.IsNotExist(err) && tally.pending[filepath.Clean(collectionFile)] == nil
+
+	var after = collectionSha1s(newColl)
+	for name, sha1 := range after {
+		var oldSha1, found = before[name]
+		if !found {
+			planned.Added = append(planned.Added, name)
+		} else if oldSha1 != sha1 {
+			planned.Rehashed = append(planned.Rehashed, name)
+		}
+	}
+	for name := range before {
+		if _, found := after[name]; !found {
+			planned.Removed = append(planned.Removed, name)
+		}
+	}
+	sort.Strings(planned.Added)
+	sort.Strings(planned.Removed)
+	sort.Strings(planned.Rehashed)
+
+	tally.plan.Collections = append(tally.plan.Collections, planned)
+}
+
+// Returns name->sha1 map of all files in collection
+func collectionSha1s(coll RSCollection) map[string]string {
+	var ret = make(map[string]string)
+	coll.Visit(func(file RSCollectionFile) {
+		ret[file.Name()] = file.Sha1()
+	})
+	return ret
+}
@@ -0,0 +1,101 @@
+package tallylib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_DryRun_will_not_write_collections(t *testing.T) {
+	var tmpdir = mktmp("Test_DryRun_will_not_write_collections")
+	defer os.RemoveAll(tmpdir)
+	var fixture = setup_dryRun()
+
+	var subdir1 = mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	var subdir2 = mkdir(subdir1, "subdir2")
+	writefile(subdir2, "file2", "Hello 2!")
+
+	if !update(t, fixture, subdir1, true) {
+		t.Log("tally did not report a change")
+		t.Fail()
+	}
+	assertPathNotExists(t, resolveCollectionFileSimple(subdir1))
+	assertPathNotExists(t, resolveCollectionFileSimple(subdir2))
+
+	var plan = fixture.GetPlan()
+	assertIntEquals(t, "planned collections", 2, len(plan.Collections))
+	var planned2 = findPlannedCollection(t, plan, resolveCollectionFileSimple(subdir2))
+	assertPlannedEntries(t, "added", []string{"file2"}, planned2.Added)
+	var planned1 = findPlannedCollection(t, plan, resolveCollectionFileSimple(subdir1))
+	assertPlannedEntries(t, "added", []string{"file1", "subdir2.rscollection"}, planned1.Added)
+	if !planned1.Created || !planned2.Created {
+		t.Log("Collections should be planned as created")
+		t.Fail()
+	}
+}
+
+func Test_DryRun_will_report_removed_and_rehashed(t *testing.T) {
+	var tmpdir = mktmp("Test_DryRun_will_report_removed_and_rehashed")
+	defer os.RemoveAll(tmpdir)
+	var fixture = createFixture()
+
+	var subdir = mkdir(tmpdir, "subdir")
+	writefile(subdir, "file1", "Hello, world!")
+	var file2 = writefile(subdir, "file2", "Hello 2!")
+	assertUpdateSingleDirectory(t, fixture, subdir)
+
+	var collectionFile = resolveCollectionFileSimple(subdir)
+	var oldTimestamp = getTimestampSafe(collectionFile)
+	fixture = setup_dryRun()
+	writefile(subdir, "file1", "Changed")
+	writefile(subdir, "file3", "Hello 3!")
+	os.Remove(file2)
+
+	if !update(t, fixture, subdir, false) {
+		t.Log("tally did not report a change")
+		t.Fail()
+	}
+	if getTimestampSafe(collectionFile) != oldTimestamp {
+		t.Log("DryRun should not touch collection file")
+		t.Fail()
+	}
+
+	var planned = findPlannedCollection(t, fixture.GetPlan(), collectionFile)
+	if planned.Created {
+		t.Log("Existing collection should not be planned as created")
+		t.Fail()
+	}
+	assertPlannedEntries(t, "added", []string{"file3"}, planned.Added)
+	assertPlannedEntries(t, "removed", []string{"file2"}, planned.Removed)
+	assertPlannedEntries(t, "rehashed", []string{"file1"}, planned.Rehashed)
+}
+
+func setup_dryRun() Tally {
+	var fixture = createFixture()
+	var config = fixture.GetConfig()
+	config.DryRun = true
+	fixture.SetConfig(config)
+	return fixture
+}
+
+func findPlannedCollection(t *testing.T, plan Plan, collectionFile string) PlannedCollection {
+	for _, planned := range plan.Collections {
+		if filepath.Clean(planned.CollectionFile) == filepath.Clean(collectionFile) {
+			return planned
+		}
+	}
+	t.Fatal("Collection", collectionFile, "not found in plan")
+	return PlannedCollection{}
+}
+
+func assertPlannedEntries(t *testing.T, context string, expected, actual []string) {
+	if len(expected) != len(actual) {
+		t.Log(context, "expected:", expected, "actual:", actual)
+		t.Fail()
+		return
+	}
+	for i := range expected {
+		assertStrEquals(t, context, expected[i], actual[i])
+	}
+}
@@ -0,0 +1,76 @@
+package tallylib
+
+import (
+	"path/filepath"
+)
+
+// ProgressListener used when none is set
+type noProgress struct{}
+
+func (noProgress) ScanFinished(files int, bytes int64)                 {}
+func (noProgress) DirectoryEntered(directory string)                   {}
+func (noProgress) HashStarted(fullpath string, size int64)             {}
+func (noProgress) HashProgress(fullpath string, bytesRead int64)       {}
+func (noProgress) HashFinished(fullpath string, size, bytesRead int64) {}
+func (noProgress) CollectionWritten(collectionFile string)             {}
+func (noProgress) ParentUpdated(directory string)                      {}
+
+func (tally *tally) SetProgressListener(listener ProgressListener) {
+	if listener == nil {
+		tally.listener = noProgress{}
+		tally.prescan = false
+	} else {
+		tally.listener = listener
+		tally.prescan = true
+	}
+	tally.progress = tally.listener
+}
+
+// Counts files that are going to be checked and reports them to the
+// progress listener. Does nothing if no listener set
+func (tally *tally) scan(directory string, recursive bool) error {
+	if !tally.prescan {
+		return nil
+	}
+	var err = tally.assertDirectory(directory)
+	if err != nil {
+		return err
+	}
+	tally.debug("Pre-scanning", directory)
+	var files int
+	var bytes int64
+	err = tally.scanDirectory(directory, recursive, &files, &bytes)
+	if err != nil {
+		return err
+	}
+	tally.debug("Pre-scan found", files, "files,", bytes, "bytes")
+	tally.progress.ScanFinished(files, bytes)
+	return nil
+}
+
+func (tally *tally) scanDirectory(directory string, recursive bool, files *int, bytes *int64) error {
+	var entries, err = tally.listDirectory(directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = tally.ctx.Err(); err != nil {
+			return err
+		}
+		if tally.isFile(entry) {
+			*files++
+			*bytes += entry.Size()
+		} else if recursive && tally.isDir(entry) {
+			var fullpath = filepath.Join(directory, entry.Name())
+			if !tally.enterDirectory(fullpath, entry) {
+				continue
+			}
+			err = tally.scanDirectory(fullpath, true, files, bytes)
+			tally.leaveDirectory()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package tallylib
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+func Test_ProgressListener_receives_events(t *testing.T) {
+	var tmpdir = mktmp("Test_ProgressListener_receives_events")
+	defer os.RemoveAll(tmpdir)
+
+	var subdir1 = mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	var subdir2 = mkdir(subdir1, "subdir2")
+	writefile(subdir2, "file2", "Hello 2!")
+
+	var fixture = createFixture()
+	var listener = new(recordingListener)
+	fixture.SetProgressListener(listener)
+	assertUpdateRecursive(t, fixture, subdir1)
+
+	assertIntEquals(t, "scanned files", 2, listener.scannedFiles)
+	assertUint64Equals(t, "scanned bytes", 21, listener.scannedBytes)
+	assertIntEquals(t, "directories entered", 2, len(listener.directories))
+	// subdir2.rscollection is written during update, so it is not scanned
+	assertIntEquals(t, "hashes started", 3, listener.hashesStarted)
+	assertIntEquals(t, "hashes finished", 3, listener.hashesFinished)
+	assertIntEquals(t, "collections written", 2, len(listener.collections))
+
+	listener = new(recordingListener)
+	fixture.SetProgressListener(listener)
+	assertWillNotUpdateRecursive(t, fixture, subdir1)
+	assertIntEquals(t, "scanned files", 3, listener.scannedFiles)
+	assertIntEquals(t, "hashes started", 0, listener.hashesStarted)
+	assertIntEquals(t, "hashes finished", 3, listener.hashesFinished)
+	assertUint64Equals(t, "bytes read", 0, listener.bytesRead)
+	assertIntEquals(t, "collections written", 0, len(listener.collections))
+}
+
+type recordingListener struct {
+	lock           sync.Mutex
+	scannedFiles   int
+	scannedBytes   int64
+	directories    []string
+	hashesStarted  int
+	hashesFinished int
+	bytesRead      int64
+	collections    []string
+	parents        []string
+}
+
+func (l *recordingListener) ScanFinished(files int, bytes int64) {
+	l.scannedFiles = files
+	l.scannedBytes = bytes
+}
+
+func (l *recordingListener) DirectoryEntered(directory string) {
+	l.directories = append(l.directories, directory)
+}
+
+func (l *recordingListener) HashStarted(fullpath string, size int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.hashesStarted++
+}
+
+func (l *recordingListener) HashProgress(fullpath string, bytesRead int64) {
+}
+
+func (l *recordingListener) HashFinished(fullpath string, size, bytesRead int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.hashesFinished++
+	l.bytesRead += bytesRead
+}
+
+func (l *recordingListener) CollectionWritten(collectionFile string) {
+	l.collections = append(l.collections, collectionFile)
+}
+
+func (l *recordingListener) ParentUpdated(directory string) {
+	l.parents = append(l.parents, directory)
+}
@@ -0,0 +1,70 @@
+package tallylib
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Counts hashing work for UpdateResult and passes all events on
+type countingListener struct {
+	ProgressListener
+	filesHashed int64 // atomic
+	bytesRead   int64 // atomic
+}
+
+func (l *countingListener) HashStarted(fullpath string, size int64) {
+	atomic.AddInt64(&l.filesHashed, 1)
+	l.ProgressListener.HashStarted(fullpath, size)
+}
+
+func (l *countingListener) HashFinished(fullpath string, size, bytesRead int64) {
+	atomic.AddInt64(&l.bytesRead, bytesRead)
+	l.ProgressListener.HashFinished(fullpath, size, bytesRead)
+}
+
+func (tally *tally) GetResult() UpdateResult {
+	return tally.result
+}
+
+func (tally *tally) resetResult() {
+	tally.result = UpdateResult{}
+	tally.counter = &countingListener{ProgressListener: tally.listener}
+	tally.progress = tally.counter
+}
+
+// Finishes Update* call started with begin()
+func (tally *tally) end() {
+	tally.saveHashCache()
+	tally.result.Collections = tally.plan.Collections
+	if tally.counter != nil {
+		tally.result.FilesHashed = int(atomic.LoadInt64(&tally.counter.filesHashed))
+		tally.result.BytesRead = atomic.LoadInt64(&tally.counter.bytesRead)
+	}
+	tally.result.Elapsed = time.Since(tally.started)
+	tally.progress = tally.listener
+}
+
+// Records problem which is ignored since TallyConfig.IgnoreWarnings=true
+func (tally *tally) skipWarning(kind error, fullpath, message string, cause error) {
+	var warning = newAccessError(kind, fullpath, message, cause)
+	tally.result.Warnings = append(tally.result.Warnings, warning)
+}
+
+// Records failed subtree if TallyConfig.ContinueOnError allows to go on
+// without it. Cancellation is never skipped
+func (tally *tally) skipFailure(directory string, err error) bool {
+	if !tally.config.ContinueOnError || tally.ctx.Err() != nil {
+		return false
+	}
+	tally.err("Skipping", directory, "because of", err)
+	var failure = newAccessError(nil, directory, "Skipped:", err)
+	tally.failures = append(tally.failures, failure)
+	return true
+}
+
+func (tally *tally) failuresError() error {
+	if len(tally.failures) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: tally.failures}
+}
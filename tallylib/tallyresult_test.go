@@ -0,0 +1,127 @@
+package tallylib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_GetResult_will_describe_last_update(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.IgnoreWarnings = true
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_GetResult_will_describe_last_update")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	subdir2 := mkdir(subdir1, "subdir2")
+	writefile(subdir2, "file2", "Hello 2")
+	noAccess := writefile(subdir2, "noaccess", "secret")
+	os.Chmod(noAccess, 0)
+
+	assertUpdateRecursive(t, fixture, subdir1)
+	var result = fixture.GetResult()
+	assertIntEquals(t, "collections", 2, len(result.Collections))
+	assertStringEquals(t, filepath.Join(tmpdir, "subdir1", "subdir2.rscollection"), result.Collections[0].CollectionFile)
+	assertIntEquals(t, "added to subdir2", 1, len(result.Collections[0].Added))
+	// noaccess can't be read, but it still counts
+	assertIntEquals(t, "files hashed", 4, result.FilesHashed)
+	var collection2, _ = os.Stat(result.Collections[0].CollectionFile)
+	assertUint64Equals(t, "bytes read", 20+collection2.Size(), result.BytesRead)
+	if len(result.Warnings) != 1 {
+		t.Fatal("expected 1 warning, got", result.Warnings)
+	}
+	if accessErr, ok := result.Warnings[0].(*AccessError); !ok || accessErr.fullpath != noAccess {
+		t.Error("unexpected warning", result.Warnings[0])
+	}
+	if result.Elapsed <= 0 {
+		t.Error("elapsed time not set")
+	}
+
+	assertWillNotUpdateRecursive(t, fixture, subdir1)
+	result = fixture.GetResult()
+	assertIntEquals(t, "collections", 0, len(result.Collections))
+	assertIntEquals(t, "files hashed", 1, result.FilesHashed)
+	assertIntEquals(t, "warnings", 1, len(result.Warnings))
+}
+
+func Test_UpdateRecursive_will_continue_on_error(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.ContinueOnError = true
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateRecursive_will_continue_on_error")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	broken := mkdir(subdir1, "broken")
+	writefile(broken, "file2", "Hello 2")
+	writefile(subdir1, "broken.rscollection", "this is not XML")
+	good := mkdir(subdir1, "good")
+	writefile(good, "file3", "Hello 3")
+
+	var changed, err = fixture.UpdateRecursive(subdir1, 0, -1)
+	if !changed {
+		t.Error("tally did not report collection changed")
+	}
+	var multi, ok = err.(*MultiError)
+	if !ok {
+		t.Fatal("expected *MultiError, got", err)
+	}
+	assertIntEquals(t, "failures", 1, len(multi.Errors))
+	if failure, ok := multi.Errors[0].(*AccessError); !ok || failure.fullpath != broken {
+		t.Error("unexpected failure", multi.Errors[0])
+	}
+
+	var coll = loadCollectionForDirectory(t, subdir1)
+	assertFileInCollection(t, coll, "file1", "")
+	assertFileInCollection(t, coll, "good.rscollection", "")
+	assertFileInCollection(t, coll, "broken.rscollection", "")
+	assertFileInCollection(t, loadCollectionForDirectory(t, good), "file3", "")
+}
+
+func Test_UpdateRecursive_will_not_fail_parent_below_MinDig(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.ContinueOnError = true
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateRecursive_will_not_fail_parent_below_MinDig")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	good := mkdir(subdir1, "good")
+	writefile(good, "file1", "Hello, world!")
+	// Failing directory is the last entry listed
+	broken := mkdir(subdir1, "zbroken")
+	writefile(broken, "file2", "Hello 2")
+	os.Chmod(broken, 0)
+	defer os.Chmod(broken, 0755)
+
+	var _, err = fixture.UpdateRecursive(subdir1, 1, -1)
+	var multi, ok = err.(*MultiError)
+	if !ok {
+		t.Fatal("expected *MultiError, got", err)
+	}
+	assertIntEquals(t, "failures", 1, len(multi.Errors))
+	if failure, ok := multi.Errors[0].(*AccessError); !ok || failure.fullpath != broken {
+		t.Error("unexpected failure", multi.Errors[0])
+	}
+	assertFileInCollection(t, loadCollectionForDirectory(t, good), "file1", "")
+}
+
+func Test_UpdateRecursive_will_stop_on_error_by_default(t *testing.T) {
+	fixture := createFixture()
+	tmpdir := mktmp("Test_UpdateRecursive_will_stop_on_error_by_default")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	broken := mkdir(subdir1, "broken")
+	writefile(broken, "file2", "Hello 2")
+	writefile(subdir1, "broken.rscollection", "this is not XML")
+
+	var _, err = fixture.UpdateRecursive(subdir1, 0, -1)
+	if _, ok := err.(*AccessError); !ok {
+		t.Error("expected *AccessError, got", err)
+	}
+	assertPathNotExists(t, resolveCollectionFileSimple(subdir1))
+}
@@ -0,0 +1,341 @@
+package tallylib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of part n (counting from 1) of collection split because of
+// TallyConfig.MaxCollectionEntries or MaxCollectionSize:
+// "Name.rscollection" becomes "Name.part<n>.rscollection"
+func partFile(collectionFile string, n int) string {
+	var ext = filepath.Ext(collectionFile)
+	return strings.TrimSuffix(collectionFile, ext) + ".part" + strconv.Itoa(n) + ext
+}
+
+// Returns collection file path is a part of and part number, or "" and 0
+// if path does not look like a part (see partFile)
+func partOf(path string) (string, int) {
+	var ext = filepath.Ext(path)
+	var stem = strings.TrimSuffix(path, ext)
+	var dot = strings.LastIndex(stem, ".part")
+	if dot < 0 {
+		return "", 0
+	}
+	var digits = stem[dot+len(".part"):]
+	var n, err = strconv.Atoi(digits)
+	if err != nil || n < 1 || strconv.Itoa(n) != digits {
+		return "", 0
+	}
+	return stem[:dot] + ext, n
+}
+
+// Returns part number if path is a part of collectionFile, 0 otherwise
+func partNumber(collectionFile, path string) int {
+	var whole, n = partOf(path)
+	if whole != collectionFile {
+		return 0
+	}
+	return n
+}
+
+// Parts of split collection as they were before update
+type collectionParts struct {
+	files    map[int]string       // by part number
+	colls    map[int]RSCollection // by part number
+	previous map[string]int       // entry name -> part number
+}
+
+func (parts *collectionParts) exist() bool {
+	return len(parts.files) > 0
+}
+
+// Returns true if part n exists and has exactly the same entries as coll
+func (parts *collectionParts) unchanged(n int, coll RSCollection) bool {
+	var old = parts.colls[n]
+	return old != nil && sameEntries(old, coll)
+}
+
+// Loads all parts of collectionFile found on disk
+func (tally *tally) loadParts(collectionFile string) (*collectionParts, error) {
+	var ret = new(collectionParts)
+	ret.files = tally.existingParts(collectionFile)
+	ret.colls = make(map[int]RSCollection)
+	ret.previous = make(map[string]int)
+	for n, file := range ret.files {
+		var coll, err = tally.loadExistingCollection(file)
+		if err != nil {
+			return nil, err
+		}
+		ret.colls[n] = coll
+		coll.Visit(func(entry RSCollectionFile) {
+			ret.previous[entry.Name()] = n
+		})
+	}
+	return ret, nil
+}
+
+// Returns part files of collectionFile by part number
+func (tally *tally) existingParts(collectionFile string) map[int]string {
+	var ret = make(map[int]string)
+	var directory = filepath.Dir(collectionFile)
+	var files, err = ioutil.ReadDir(directory)
+	if err != nil {
+		tally.debug("Cannot list", directory, "for parts of", collectionFile, err)
+		return ret
+	}
+	for _, file := range files {
+		var path = filepath.Join(directory, file.Name())
+		if n := partNumber(collectionFile, path); n > 0 && tally.isFile(file) && !tally.removed[path] {
+			ret[n] = path
+		}
+	}
+	if len(ret) > 0 {
+		// "X.part1.rscollection" may as well be collection of directory
+		// "X.part1" sitting next to "X"
+		var siblings = tally.directoryCollections(directory, files)
+		for n, path := range ret {
+			if siblings[path] {
+				delete(ret, n)
+			}
+		}
+	}
+	return ret
+}
+
+// Returns collectionFile, if it exists, followed by its parts in order
+func (tally *tally) collectionFiles(collectionFile string) []string {
+	var ret []string
+	if _, err := os.Stat(collectionFile); !os.IsNotExist(err) {
+		ret = append(ret, collectionFile)
+	}
+	var parts = tally.existingParts(collectionFile)
+	for _, n := range partNumbers(parts) {
+		ret = append(ret, parts[n])
+	}
+	return ret
+}
+
+// Returns part numbers in ascending order
+func partNumbers(files map[int]string) []int {
+	var ret = make([]int, 0, len(files))
+	for n := range files {
+		ret = append(ret, n)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// Writes coll to collectionFile or, if it exceeds limits, to its parts.
+// Files which are no longer needed are removed. Returns true if anything
+// was written or removed
+func (tally *tally) writeCollection(
+	changed bool,
+	collectionFile string,
+	before map[string]string,
+	parts *collectionParts,
+	coll RSCollection) (bool, error) {
+
+	var split = tally.splitCollection(coll, parts.previous)
+	if split == nil {
+		if changed || parts.exist() {
+			tally.recordPlan(collectionFile, before, coll)
+			var err = tally.storeCollectionToFile(coll, collectionFile)
+			if err != nil {
+				return true, err
+			}
+			changed = true
+		}
+		return tally.removeParts(changed, parts, nil)
+	}
+
+	changed = false
+	for i, part := range split {
+		var n = i + 1
+		if part == nil {
+			continue
+		}
+		if parts.unchanged(n, part) && !tally.hasTimestampsToStrip(part) {
+			continue
+		}
+		var file = partFile(collectionFile, n)
+		var partBefore = make(map[string]string)
+		if parts.colls[n] != nil {
+			partBefore = collectionSha1s(parts.colls[n])
+		}
+		tally.info("Writing part", n, "of", collectionFile)
+		tally.recordPlan(file, partBefore, part)
+		var err = tally.storeCollectionToFile(part, file)
+		if err != nil {
+			return true, err
+		}
+		changed = true
+	}
+	if _, err := os.Stat(collectionFile); err == nil && !tally.removed[collectionFile] {
+		err = tally.removeCollectionFile(collectionFile, before)
+		if err != nil {
+			return true, err
+		}
+		changed = true
+	}
+	return tally.removeParts(changed, parts, split)
+}
+
+// Removes parts which split (see splitCollection) has no entries for
+func (tally *tally) removeParts(changed bool, parts *collectionParts, split []RSCollection) (bool, error) {
+	for _, n := range partNumbers(parts.files) {
+		if n <= len(split) && split[n-1] != nil {
+			continue
+		}
+		var err = tally.removeCollectionFile(parts.files[n], collectionSha1s(parts.colls[n]))
+		if err != nil {
+			return true, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// Removes collection file which is no longer needed, along with its backups
+func (tally *tally) removeCollectionFile(collectionFile string, before map[string]string) error {
+	var planned PlannedCollection
+	planned.CollectionFile = collectionFile
+	planned.Deleted = true
+	for name := range before {
+		planned.Removed = append(planned.Removed, name)
+	}
+	sort.Strings(planned.Removed)
+	tally.plan.Collections = append(tally.plan.Collections, planned)
+
+	if tally.config.DryRun {
+		tally.info("DryRun: not removing", collectionFile)
+		tally.removed[filepath.Clean(collectionFile)] = true
+		return nil
+	}
+	tally.info("Removing obsolete", collectionFile)
+	var err = os.Remove(collectionFile)
+	if err != nil && !os.IsNotExist(err) {
+		return tally.kindError(ErrWrite, collectionFile, "Cannot remove", err)
+	}
+	for version := 1; version <= tally.config.CollectionBackups; version++ {
+		err = os.Remove(backupName(collectionFile, version))
+		if err != nil && !os.IsNotExist(err) {
+			return tally.kindError(ErrWrite, collectionFile, "Cannot remove backup", err)
+		}
+	}
+	return nil
+}
+
+// Part of collection being split
+type collectionPart struct {
+	coll    RSCollection
+	entries int
+	size    int64
+}
+
+func newCollectionPart() *collectionPart {
+	var ret = new(collectionPart)
+	ret.coll = NewCollection()
+	ret.coll.InitEmpty()
+	return ret
+}
+
+func (part *collectionPart) add(file RSCollectionFile) {
+	part.coll.UpdateFile(file)
+	part.entries++
+	part.size += file.Size()
+}
+
+func (tally *tally) exceedsLimits(entries int, size int64) bool {
+	var maxEntries = tally.config.MaxCollectionEntries
+	var maxSize = tally.config.MaxCollectionSize
+	return (maxEntries > 0 && entries > maxEntries) || (maxSize > 0 && size > maxSize)
+}
+
+// Single file always fits into empty part, even if it is too big
+func (tally *tally) fits(part *collectionPart, file RSCollectionFile) bool {
+	return part.entries == 0 || !tally.exceedsLimits(part.entries+1, part.size+file.Size())
+}
+
+// Splits coll into parts within TallyConfig.MaxCollectionEntries and
+// MaxCollectionSize. Entries stay in the part they were in before (see
+// collectionParts.previous) while it has room, the rest fill parts with
+// room in name order, starting with the first one. Part n is returned at
+// index n-1 and is nil if it is left empty, so parts are never renumbered.
+// Returns nil if coll does not need splitting
+func (tally *tally) splitCollection(coll RSCollection, previous map[string]int) []RSCollection {
+	var names = make([]string, 0, coll.Size())
+	var size int64
+	coll.Visit(func(file RSCollectionFile) {
+		names = append(names, file.Name())
+		size += file.Size()
+	})
+	if len(names) <= 1 || !tally.exceedsLimits(len(names), size) {
+		return nil
+	}
+	sort.Strings(names)
+
+	var parts []*collectionPart
+	var rest []RSCollectionFile
+	for _, name := range names {
+		var file = coll.ByName(name)
+		var n, found = previous[name]
+		if found {
+			for len(parts) < n {
+				parts = append(parts, newCollectionPart())
+			}
+			if tally.fits(parts[n-1], file) {
+				parts[n-1].add(file)
+				continue
+			}
+		}
+		rest = append(rest, file)
+	}
+	for _, file := range rest {
+		var placed = false
+		for _, part := range parts {
+			if tally.fits(part, file) {
+				part.add(file)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			var part = newCollectionPart()
+			part.add(file)
+			parts = append(parts, part)
+		}
+	}
+
+	var ret = make([]RSCollection, len(parts))
+	for i, part := range parts {
+		if part.entries > 0 {
+			ret[i] = part.coll
+		}
+	}
+	for len(ret) > 0 && ret[len(ret)-1] == nil {
+		ret = ret[:len(ret)-1]
+	}
+	return ret
+}
+
+// Returns true if both collections have same entries with same sha1,
+// size and timestamp
+func sameEntries(a, b RSCollection) bool {
+	if a.Size() != b.Size() {
+		return false
+	}
+	var ret = true
+	a.Visit(func(file RSCollectionFile) {
+		var other = b.ByName(file.Name())
+		if other == nil || other.Sha1() != file.Sha1() || other.Size() != file.Size() ||
+			!other.Timestamp().Equal(file.Timestamp()) {
+			ret = false
+		}
+	})
+	return ret
+}
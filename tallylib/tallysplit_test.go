@@ -0,0 +1,209 @@
+package tallylib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_partOf(t *testing.T) {
+	var whole, n = partOf("/dir/Name.part12.rscollection")
+	assertStringEquals(t, "/dir/Name.rscollection", whole)
+	assertIntEquals(t, "part", 12, n)
+	assertStringEquals(t, "/dir/Name.part3.rscollection", partFile("/dir/Name.rscollection", 3))
+	for _, path := range []string{"/dir/Name.rscollection", "/dir/Name.part.rscollection", "/dir/Name.part0.rscollection", "/dir/Name.part01.rscollection"} {
+		if _, n = partOf(path); n != 0 {
+			t.Error(path, "is not a part")
+		}
+	}
+}
+
+func Test_splitCollection_will_keep_big_files_apart(t *testing.T) {
+	var fixture = createFixture().(*tally)
+	fixture.config.MaxCollectionSize = 100
+	var coll = NewCollection()
+	coll.InitEmpty()
+	coll.Update("a", "sha1a", 60, time.Time{})
+	coll.Update("b", "sha1b", 500, time.Time{})
+	coll.Update("c", "sha1c", 40, time.Time{})
+	coll.Update("d", "sha1d", 10, time.Time{})
+
+	var parts = fixture.splitCollection(coll, map[string]int{"d": 2})
+	assertIntEquals(t, "parts", 3, len(parts))
+	assertFileInCollection(t, parts[0], "a", "")
+	assertFileInCollection(t, parts[0], "c", "")
+	assertCollectionSize(t, 2, parts[0])
+	assertFileInCollection(t, parts[1], "d", "")
+	assertCollectionSize(t, 1, parts[1])
+	assertFileInCollection(t, parts[2], "b", "")
+	assertCollectionSize(t, 1, parts[2])
+
+	fixture.config.MaxCollectionSize = 1000
+	if fixture.splitCollection(coll, nil) != nil {
+		t.Error("collection within limits must not be split")
+	}
+}
+
+func Test_UpdateRecursive_will_split_big_collections(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_split_big_collections")
+	defer os.RemoveAll(tmpdir)
+	root := mkdir(tmpdir, "root")
+	big := mkdir(root, "big")
+	for _, name := range []string{"file1", "file2", "file3", "file4", "file5", "file6", "file7"} {
+		writefile(big, name, name)
+	}
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.MaxCollectionEntries = 3
+	config.UpdateParents = false
+	fixture.SetConfig(config)
+
+	assertUpdateRecursive(t, fixture, root)
+	assertPathNotExists(t, resolveCollectionFileSimple(big))
+	assertParts(t, root, "big", [][]string{{"file1", "file2", "file3"}, {"file4", "file5", "file6"}, {"file7"}})
+	var coll = loadCollectionForDirectory(t, root)
+	assertFileInCollection(t, coll, "big.part1.rscollection", "")
+	assertFileInCollection(t, coll, "big.part3.rscollection", "")
+	assertCollectionSize(t, 3, coll)
+
+	// New file goes to the first part with room, others stay
+	writefile(big, "file0", "file0")
+	assertUpdateRecursive(t, fixture, root)
+	assertParts(t, root, "big", [][]string{{"file1", "file2", "file3"}, {"file4", "file5", "file6"}, {"file0", "file7"}})
+
+	// Emptied part is dropped, others keep their numbers
+	for _, name := range []string{"file4", "file5", "file6"} {
+		os.Remove(filepath.Join(big, name))
+	}
+	assertUpdateRecursive(t, fixture, root)
+	assertParts(t, root, "big", [][]string{{"file1", "file2", "file3"}, nil, {"file0", "file7"}})
+	coll = loadCollectionForDirectory(t, root)
+	assertFileInCollection(t, coll, "big.part3.rscollection", "")
+	assertCollectionSize(t, 2, coll)
+
+	// Collection which fits again is no longer split
+	os.Remove(filepath.Join(big, "file0"))
+	os.Remove(filepath.Join(big, "file7"))
+	assertUpdateRecursive(t, fixture, root)
+	coll = loadCollectionForDirectory(t, big)
+	assertCollectionSize(t, 3, coll)
+	assertPathNotExists(t, filepath.Join(root, "big.part1.rscollection"))
+	assertPathNotExists(t, filepath.Join(root, "big.part3.rscollection"))
+	coll = loadCollectionForDirectory(t, root)
+	assertFileInCollection(t, coll, "big.rscollection", "")
+	assertCollectionSize(t, 1, coll)
+}
+
+func Test_UpdateRecursive_will_not_mistake_sibling_directory_for_part(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_not_mistake_sibling_directory_for_part")
+	defer os.RemoveAll(tmpdir)
+	root := mkdir(tmpdir, "root")
+	writefile(mkdir(root, "Movie"), "movie.mkv", "movie")
+	writefile(mkdir(root, "Movie.part1"), "extras.mkv", "extras")
+	fixture := createFixture()
+
+	assertUpdateRecursive(t, fixture, root)
+	if update(t, fixture, root, true) {
+		t.Error("second update must not change anything")
+	}
+	assertCollectionSize(t, 1, loadCollectionForDirectory(t, filepath.Join(root, "Movie")))
+	var extras = loadCollectionForDirectory(t, filepath.Join(root, "Movie.part1"))
+	assertFileInCollection(t, extras, "extras.mkv", "")
+	var coll = loadCollectionForDirectory(t, root)
+	assertFileInCollection(t, coll, "Movie.rscollection", "")
+	assertFileInCollection(t, coll, "Movie.part1.rscollection", "")
+	assertCollectionSize(t, 2, coll)
+
+	var config = fixture.GetConfig()
+	config.DryRun = true
+	fixture.SetConfig(config)
+	writefile(filepath.Join(root, "Movie"), "poster.jpg", "poster")
+	update(t, fixture, root, true)
+	for _, planned := range fixture.GetPlan().Collections {
+		if planned.Deleted {
+			t.Error("nothing should be deleted, got", planned.CollectionFile)
+		}
+	}
+
+	config.DryRun = false
+	fixture.SetConfig(config)
+	assertUpdateRecursive(t, fixture, root)
+	assertCollectionSize(t, 2, loadCollectionForDirectory(t, filepath.Join(root, "Movie")))
+	assertCollectionSize(t, 1, loadCollectionForDirectory(t, filepath.Join(root, "Movie.part1")))
+}
+
+func Test_UpdateSingleDirectory_will_not_renumber_parts(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateSingleDirectory_will_not_renumber_parts")
+	defer os.RemoveAll(tmpdir)
+	big := mkdir(tmpdir, "big")
+	for _, name := range []string{"a", "b", "c", "e", "f", "g"} {
+		writefile(big, name, name)
+	}
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.MaxCollectionEntries = 2
+	fixture.SetConfig(config)
+	update(t, fixture, big, false)
+	assertParts(t, tmpdir, "big", [][]string{{"a", "b"}, {"c", "e"}, {"f", "g"}})
+
+	os.Remove(filepath.Join(big, "a"))
+	os.Remove(filepath.Join(big, "b"))
+	update(t, fixture, big, false)
+	assertParts(t, tmpdir, "big", [][]string{nil, {"c", "e"}, {"f", "g"}})
+	var plan = fixture.GetPlan()
+	assertIntEquals(t, "planned collections", 1, len(plan.Collections))
+	if !findPlannedCollection(t, plan, filepath.Join(tmpdir, "big.part1.rscollection")).Deleted {
+		t.Error("empty part should be deleted")
+	}
+
+	// New files fill the gap
+	writefile(big, "d", "d")
+	update(t, fixture, big, false)
+	assertParts(t, tmpdir, "big", [][]string{{"d"}, {"c", "e"}, {"f", "g"}})
+	assertIntEquals(t, "planned collections", 1, len(fixture.GetPlan().Collections))
+}
+
+func assertParts(t *testing.T, directory, name string, expected [][]string) {
+	for i, names := range expected {
+		if names == nil {
+			assertPathNotExists(t, partFile(filepath.Join(directory, name+".rscollection"), i+1))
+			continue
+		}
+		var coll = loadCollection(t, partFile(filepath.Join(directory, name+".rscollection"), i+1))
+		for _, entry := range names {
+			assertFileInCollection(t, coll, entry, "")
+		}
+		assertCollectionSize(t, len(names), coll)
+	}
+	assertPathNotExists(t, partFile(filepath.Join(directory, name+".rscollection"), len(expected)+1))
+}
+
+func Test_DryRun_will_plan_split_without_removing(t *testing.T) {
+	tmpdir := mktmp("Test_DryRun_will_plan_split_without_removing")
+	defer os.RemoveAll(tmpdir)
+	big := mkdir(tmpdir, "big")
+	for _, name := range []string{"file1", "file2", "file3"} {
+		writefile(big, name, name)
+	}
+	fixture := createFixture()
+	assertUpdateSingleDirectory(t, fixture, big)
+
+	var config = fixture.GetConfig()
+	config.MaxCollectionEntries = 2
+	config.DryRun = true
+	fixture.SetConfig(config)
+	update(t, fixture, big, false)
+
+	var plan = fixture.GetPlan()
+	assertIntEquals(t, "planned collections", 3, len(plan.Collections))
+	var removed = findPlannedCollection(t, plan, resolveCollectionFileSimple(big))
+	if !removed.Deleted {
+		t.Error("collection should be planned as deleted")
+	}
+	assertPlannedEntries(t, "removed", []string{"file1", "file2", "file3"}, removed.Removed)
+	var part2 = findPlannedCollection(t, plan, filepath.Join(tmpdir, "big.part2.rscollection"))
+	assertPlannedEntries(t, "added", []string{"file3"}, part2.Added)
+	assertCollectionSize(t, 3, loadCollectionForDirectory(t, big))
+	assertPathNotExists(t, filepath.Join(tmpdir, "big.part1.rscollection"))
+}
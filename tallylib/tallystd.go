@@ -1,6 +1,8 @@
 package tallylib
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -8,6 +10,7 @@ import (
 	"path/filepath"
 	"text/template"
 	"strings"
+	"time"
 )
 
 // Holds settings
@@ -20,12 +23,38 @@ type tally struct {
 	loggerInfo  *log.Logger
 	loggerErr   *log.Logger
 	loggerWarn  *log.Logger
+	ctx         context.Context // of the current Update* call
+	listener    ProgressListener // set by SetProgressListener
+	progress    ProgressListener // listener, wrapped by counter during Update* call
+	counter     *countingListener
+	prescan     bool // true if progress listener wants pre-scan
+	exclude     patternList // compiled TallyConfig.Exclude
+	include     patternList // compiled TallyConfig.Include
+	ignoreFiles map[string]patternList // excludesFor cache, by directory
+	dirSettings map[string]*dirSettings // settingsFor cache, by directory
+	plan        Plan
+	pending     map[string]*pendingCollection // DryRun collections, by path
+	removed     map[string]bool // DryRun removed collections, by path
+	ancestors   []os.FileInfo // directories being processed, to detect symlink cycles
+	realRoot    string        // root with symlinks resolved, see SymlinksWithinRoot
+	cache       *hashCache    // nil unless TallyConfig.HashCacheDir is set
+	started     time.Time     // of the current Update* call
+	result      UpdateResult
+	failures    []error // subtrees skipped because of ContinueOnError
+	root        string  // directory the current Update* call was made with
+	boundary    string  // absolute TallyConfig.SettingsBoundary, see readsSettings
+	stats       map[string]*directoryStats // directoryStats cache, by directory
+	statsWalk   []os.FileInfo // directories being walked by directoryStats
 }
 
 func NewTally() Tally {
 	var ret = new(tally)
 	ret.config.LogVerbosity = 3
+	ret.config.HashWorkers = 1
+	ret.config.WatchDebounce = 2 * time.Second
 	ret.config.CollectionPathnameExpression = "{{.Path 0}}.rscollection"
+	ret.ctx = context.Background()
+	ret.SetProgressListener(nil)
 	ret.SetLog(ioutil.Discard)
 	return ret
 }
@@ -37,6 +66,7 @@ func (tally *tally) GetConfig() TallyConfig {
 func (tally *tally) SetConfig(cfg TallyConfig) {
 	tally.config = cfg
 	tally.collectionPathnameTemplate = nil
+	tally.collectionRootPathTemplate = nil
 }
 
 func (tally *tally) SetLog(logfile io.Writer) {
@@ -53,8 +83,70 @@ func (tally *tally) init(directory string) (string, error)  {
 	return ret, err
 }
 
+func (tally *tally) GetPlan() Plan {
+	return tally.plan
+}
+
+// Prepares for new Update* call on directory. Must be paired with end()
+func (tally *tally) begin(ctx context.Context, directory string) error {
+	tally.ctx = ctx
+	tally.started = time.Now()
+	tally.resetPlan()
+	tally.resetResult()
+	tally.failures = nil
+	tally.root = filepath.Clean(directory)
+	tally.boundary = ""
+	if tally.config.SettingsBoundary != "" {
+		tally.boundary, _ = filepath.Abs(tally.config.SettingsBoundary)
+	}
+	tally.stats = make(map[string]*directoryStats)
+	tally.dirSettings = make(map[string]*dirSettings)
+	tally.initSymlinks(filepath.Clean(directory))
+	tally.initHashCache(directory)
+	var err = tally.ensureTemplatesCompiled()
+	if err != nil {
+		return err
+	}
+	return tally.compilePatterns(filepath.Clean(directory))
+}
+
+// Problems with hash cache are not fatal, files just get rehashed
+func (tally *tally) initHashCache(directory string) {
+	tally.cache = nil
+	if tally.config.HashCacheDir == "" {
+		return
+	}
+	var root, err = filepath.Abs(directory)
+	if err != nil {
+		tally.warn("Not using hash cache for", directory, err)
+		return
+	}
+	tally.cache, err = loadHashCache(tally.config.HashCacheDir, root)
+	if err != nil {
+		tally.warn("Ignoring unreadable hash cache", tally.cache.path, err)
+	}
+	tally.cache.force = tally.config.ForceUpdate
+}
+
+func (tally *tally) saveHashCache() {
+	if err := tally.cache.save(); err != nil {
+		tally.warn("Cannot save hash cache", tally.cache.path, err)
+	}
+	tally.cache = nil
+}
+
 func (tally *tally) UpdateRecursive(directory string, minDig,maxDig int) (bool, error)  {
-	var normalizedPath, err = tally.init(directory)
+	return tally.UpdateRecursiveContext(context.Background(), directory, minDig, maxDig)
+}
+
+func (tally *tally) UpdateRecursiveContext(ctx context.Context, directory string, minDig,maxDig int) (bool, error)  {
+	defer tally.end()
+	var err = tally.begin(ctx, directory)
+	if err != nil {
+		return false, err
+	}
+	var normalizedPath string
+	normalizedPath, err = tally.init(directory)
 	if err != nil {
 		return false, err
 	}
@@ -63,12 +155,16 @@ func (tally *tally) UpdateRecursive(directory string, minDig,maxDig int) (bool,
 	if err != nil {
 		return false, err
 	}
+	err = tally.scan(normalizedPath, true)
+	if err != nil {
+		return false, err
+	}
 	
 	tally.debug("Stage1: updating children")
 	var ret bool
 	
 	ret, err = tally.updateChildren(normalizedPath, minDig, maxDig, 0)
-	if err != nil {
+	if err != nil && !tally.skipFailure(normalizedPath, err) {
 		return ret, err
 	}
 
@@ -76,28 +172,50 @@ func (tally *tally) UpdateRecursive(directory string, minDig,maxDig int) (bool,
 	if tally.config.UpdateParents && ret {
 		tally.debug("Stage2: updating parents")
 		_, err = tally.updateParents(normalizedPath)
+		if err != nil && !tally.skipFailure(normalizedPath, err) {
+			return ret, err
+		}
 	}
 
-	return ret, err
+	return ret, tally.failuresError()
 }
 
 func (tally *tally) updateChildren(directory string, minDig, maxDig, depth int) (bool, error) {
+	var settings, err = tally.settingsFor(directory)
+	if err != nil {
+		return false, err
+	}
+	if own, ownMax, found := settings.own.digLimits(); found {
+		tally.debug("Using dig limits of", directory, "from", dirConfigFileName)
+		minDig, maxDig, depth = own, ownMax, 0
+	}
 	if maxDig>=0 && depth>=maxDig {
-		return tally.UpdateSingleDirectory(directory, true)
+		return tally.updateSingleDirectory(directory, true)
 	}
 
-	var files, err = tally.listDirectory(directory)
+	var files []os.FileInfo
+	files, err = tally.listDirectory(directory)
 	var ret = false	
 	var changed = false
 	
 	for _, file := range files {
+		if err = tally.ctx.Err(); err != nil {
+			return ret, err
+		}
 		if tally.isDir(file) {
 			tally.debug("Invoking updateChildren(", file.Name(), ")")
 			var fullpath = filepath.Join(directory, file.Name())
+			if !tally.enterDirectory(fullpath, file) {
+				continue
+			}
 			changed, err = tally.updateChildren(fullpath, minDig, maxDig, depth+1)
+			tally.leaveDirectory()
 			ret = ret || changed
 			if err != nil {
-				return ret, err
+				if !tally.skipFailure(fullpath, err) {
+					return ret, err
+				}
+				err = nil
 			}
 		} else {
 			tally.debug("Skipping file cause it is not directory", file.Name())
@@ -105,7 +223,7 @@ func (tally *tally) updateChildren(directory string, minDig, maxDig, depth int)
 	}
 
 	if minDig<=depth {
-		changed, err = tally.UpdateSingleDirectory(directory, false)
+		changed, err = tally.updateSingleDirectory(directory, false)
 		ret = ret || changed
 		if err != nil {
 			return ret, err
@@ -120,6 +238,9 @@ func (tally *tally) updateParents(directory string) (bool, error) {
 	var err error
 	
 	for parent := filepath.Dir(directory); err == nil && parent != "/"; parent = filepath.Dir(parent) {
+		if err = tally.ctx.Err(); err != nil {
+			return ret, err
+		}
 		var collectionFile string 
 		collectionFile, err = tally.resolveCollectionFileForDirectory(parent)
 		if err != nil {
@@ -127,8 +248,12 @@ func (tally *tally) updateParents(directory string) (bool, error) {
 		}
 		var stat os.FileInfo
 		stat, err = os.Stat(collectionFile)
+		if os.IsNotExist(err) && len(tally.existingParts(collectionFile)) > 0 {
+			tally.debug("Collection", collectionFile, "is split into parts")
+			stat, err = nil, nil
+		}
 		if err == nil {
-			if !tally.isFile(stat) {
+			if stat != nil && !tally.isFile(stat) {
 				tally.info("Stopping updating patents, file", collectionFile, "is not a regualr file")
 				break
 			} else {
@@ -136,11 +261,14 @@ func (tally *tally) updateParents(directory string) (bool, error) {
 				tally.info("Updating parent", parent)
 
 				var changed bool
-				changed, err = tally.UpdateSingleDirectory(parent, false)
+				changed, err = tally.updateSingleDirectory(parent, false)
 				ret = ret || changed
 				if err != nil {
 					return ret, err
 				}
+				if changed {
+					tally.progress.ParentUpdated(parent)
+				}
 			}
 		} else {
 			if os.IsNotExist(err) {
@@ -155,7 +283,28 @@ func (tally *tally) updateParents(directory string) (bool, error) {
 }
 
 func (tally *tally) UpdateSingleDirectory(directory string, addChildren bool) (bool, error) {
-	var normalizedPath, err = tally.init(directory)
+	return tally.UpdateSingleDirectoryContext(context.Background(), directory, addChildren)
+}
+
+func (tally *tally) UpdateSingleDirectoryContext(ctx context.Context, directory string, addChildren bool) (bool, error) {
+	defer tally.end()
+	var err = tally.begin(ctx, directory)
+	if err == nil {
+		err = tally.scan(filepath.Clean(directory), addChildren)
+	}
+	if err != nil {
+		return false, err
+	}
+	return tally.updateSingleDirectory(directory, addChildren)
+}
+
+func (tally *tally) updateSingleDirectory(directory string, addChildren bool) (bool, error) {
+	var err = tally.ctx.Err()
+	if err != nil {
+		return false, err
+	}
+	var normalizedPath string
+	normalizedPath, err = tally.init(directory)
 	if err != nil {
 		return false, err
 	}
@@ -181,6 +330,30 @@ func (tally *tally) UpdateSingleDirectory(directory string, addChildren bool) (b
 		tally.debug("Error loading from ", collectionFile, err)
 		return false, err
 	}
+	var before = collectionSha1s(oldColl)
+	var strip = tally.hasTimestampsToStrip(oldColl)
+	var parts *collectionParts
+	parts, err = tally.loadParts(collectionFile)
+	if err != nil {
+		return false, err
+	}
+	// Files found in parts of split collection are not rehashed either
+	for _, part := range parts.colls {
+		part.Visit(oldColl.UpdateFile)
+	}
+	var previous map[string]RSCollection
+	previous, err = tally.previousCollections(normalizedPath, collectionFile)
+	if err != nil {
+		return false, err
+	}
+	// Nor are files found in collection written under previous name
+	for _, coll := range previous {
+		coll.Visit(func(file RSCollectionFile) {
+			if oldColl.ByName(file.Name()) == nil {
+				oldColl.UpdateFile(file)
+			}
+		})
+	}
 	newColl = NewCollection()
 	newColl.InitEmpty()
 
@@ -191,7 +364,16 @@ func (tally *tally) UpdateSingleDirectory(directory string, addChildren bool) (b
 		return false, err
 	}
 	
-	ret, err = tally.updateSingleWithRecursion(root, normalizedPath, addChildren, oldColl, newColl)
+	var pool = tally.startHashing(newColl)
+	err = tally.updateSingleWithRecursion(pool, root, normalizedPath, addChildren, oldColl)
+	var finishErr error
+	ret, finishErr = pool.finish()
+	if err == nil {
+		err = finishErr
+	}
+	if tally.ctx.Err() != nil {
+		return tally.storeInterrupted(ret, collectionFile, before, parts, oldColl, newColl)
+	}
 	if err != nil {
 		return ret, err
 	}
@@ -204,16 +386,45 @@ func (tally *tally) UpdateSingleDirectory(directory string, addChildren bool) (b
 		ret = ret || changed
 	}
 
-	if ret {
-		// Collection has been modified, need to write it back
-		err = tally.storeCollectionToFile(newColl, collectionFile)
+	// Collection is written back if it has been modified
+	ret, err = tally.writeCollection(ret || strip || len(previous) > 0, collectionFile, before, parts, newColl)
+	if err != nil {
+		return ret, err
 	}
+	return tally.removePreviousCollections(ret, previous)
+}
 
-	return ret, err
+// Called when cancelled in the middle of a directory. Files not processed
+// yet keep their old records, so collection stays consistent and files
+// hashed so far do not have to be rehashed next time
+func (tally *tally) storeInterrupted(
+	changed bool,
+	collectionFile string,
+	before map[string]string,
+	parts *collectionParts,
+	oldColl, newColl RSCollection) (bool, error) {
+
+	oldColl.Visit(func(file RSCollectionFile) {
+		newColl.UpdateFile(file)
+	})
+	if changed {
+		tally.info("Interrupted, saving files hashed so far to", collectionFile)
+		var err error
+		changed, err = tally.writeCollection(changed, collectionFile, before, parts, newColl)
+		if err != nil {
+			return changed, err
+		}
+	}
+	return changed, tally.ctx.Err()
 }
 
 func (tally *tally) resolveCollectionRootPathForDirectory(directory string) (string, error) {
-	var ret, err = tally.resolveTemplate(tally.collectionRootPathTemplate, directory)
+	var settings, err = tally.settingsFor(directory)
+	if err != nil {
+		return "", err
+	}
+	var ret string
+	ret, err = tally.resolveTemplate(settings.rootPathTemplate, directory)
 	if err == nil {
 		tally.debug("Resolved root path", ret, "for directory", directory)
 	}
@@ -225,35 +436,41 @@ func (tally *tally) resolveCollectionRootPathForDirectory(directory string) (str
 //             filesystems may have different separators
 //  collpath - rscollection path, separator is always '/' therefore joined by
 //             colljoin function
+// Files found are submitted to the pool, which puts them into new collection
 func (tally *tally) updateSingleWithRecursion(
+	pool *hashPool,
 	collpath, fullpath string, 
 	addChildren bool, 
-	oldColl, newColl RSCollection) (bool, error) {
+	oldColl RSCollection) error {
 
 	tally.debug("updateSingle(", collpath, fullpath, addChildren, "...)")
+	tally.progress.DirectoryEntered(fullpath)
 
 	var files, err = tally.listDirectory(fullpath)
-	var ret = false
-	var changed bool
 
 	for _, file := range files {
+		if err = tally.ctx.Err(); err != nil {
+			return err
+		}
 		var name = file.Name()
 		var childFullpath = filepath.Join(fullpath, name)
 		var childCollpath = colljoin(collpath, name)
 		if tally.isFile(file) {
 			tally.debug("Working on file", name)
-			changed, err = tally.updateSingleFileInDir(childCollpath, childFullpath, oldColl, newColl)
-			ret = ret || changed
+			err = tally.updateSingleFileInDir(pool, childCollpath, childFullpath, oldColl)
 			if err != nil {
-				return ret, err
+				return err
 			}
 		} else if tally.isDir(file) {
 			if addChildren {
+				if !tally.enterDirectory(childFullpath, file) {
+					continue
+				}
 				tally.info("Adding directory", childCollpath, "to the collection")
-				changed, err = tally.updateSingleWithRecursion(childCollpath, childFullpath, true, oldColl, newColl)
-				ret = ret || changed
+				err = tally.updateSingleWithRecursion(pool, childCollpath, childFullpath, true, oldColl)
+				tally.leaveDirectory()
 				if err != nil {
-					return ret, err
+					return err
 				}
 			}
 		} else {
@@ -261,25 +478,22 @@ func (tally *tally) updateSingleWithRecursion(
 		}
 	}
 
-	return ret, err
+	return err
 }
 
-func (tally *tally) updateSingleFileInDir(collpath, fullpath string, oldColl, newColl RSCollection) (bool, error) {
+func (tally *tally) updateSingleFileInDir(pool *hashPool, collpath, fullpath string, oldColl RSCollection) error {
 	var oldFile = oldColl.ByName(collpath)
 	oldColl.RemoveFile(collpath)
 
 	if oldFile != nil {
-		newColl.UpdateFile(oldFile)
+		pool.coll.UpdateFile(oldFile)
 	}
-	changed, err := tally.updateFile(collpath, fullpath, newColl)
-	if err != nil {
-		return changed, err
+	var existing = oldFile
+	if tally.config.ForceUpdate {
+		existing = nil
 	}
-	if changed {
-		tally.info("Detected change in", collpath)
-	}
-
-	return changed, nil
+	tally.debug("Checking file", fullpath)
+	return pool.submit(collpath, fullpath, existing)
 }
 
 func (tally *tally) listDirectory(directory string) ([]os.FileInfo, error) {
@@ -289,10 +503,40 @@ func (tally *tally) listDirectory(directory string) ([]os.FileInfo, error) {
 		err = tally.accessError(directory, "Can't list", err)
 		return nil, err
 	}
+	files = tally.resolveSymlinks(directory, files)
+	files = tally.skipOwnFiles(directory, files)
+	files, err = tally.filterExcluded(directory, files)
+	if err != nil {
+		return nil, err
+	}
+	files = tally.appendPendingFiles(directory, files)
 	tally.debug("Got", len(files), "entries")
 	return files, nil
 }
 
+// Removes temporary files and collection backups made by tally. Backups
+// are told apart from user files by the collection they are backups of
+func (tally *tally) skipOwnFiles(directory string, files []os.FileInfo) []os.FileInfo {
+	var collections map[string]bool
+	if tally.config.CollectionBackups > 0 {
+		collections = tally.subdirectoryCollections(directory, files)
+	}
+	var ret = make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		var name = file.Name()
+		if isTempFile(name) {
+			tally.debug("Skipping temporary file", name)
+		} else if name == ignoreFileName || name == dirConfigFileName {
+			tally.debug("Skipping", name)
+		} else if whole := backupOf(name); whole != "" && collections[filepath.Join(directory, whole)] {
+			tally.debug("Skipping backup", name)
+		} else {
+			ret = append(ret, file)
+		}
+	}
+	return ret
+}
+
 func (tally *tally) assertDirectory(directory string) error {
 	var stat, err = os.Stat(directory)
 	if err != nil {
@@ -305,7 +549,7 @@ func (tally *tally) assertDirectory(directory string) error {
 		return err
 	}
 	if !tally.isDir(stat) {
-		err = tally.accessError(directory, "supplied path is not a directory", nil)
+		err = tally.kindError(ErrNotDirectory, directory, "supplied path is not a directory", nil)
 		tally.err(err)
 		return err
 	}
@@ -316,10 +560,13 @@ func (tally *tally) removeMissingFiles(directory string, oldColl, newColl RSColl
 	var ret = false
 	oldColl.Visit( func(rsfile RSCollectionFile) {
 		var fullpath = filepath.Join(directory, rsfile.Name())
-		var _, err = os.Stat(fullpath)
+		var stat, err = os.Stat(fullpath)
 		if err != nil && os.IsNotExist(err) {
 			tally.info("File", fullpath, "has gone from disk, removing")
 			ret = true
+		} else if err == nil && tally.isFilteredOut(directory, fullpath, stat) {
+			tally.info("File", fullpath, "is excluded, removing")
+			ret = true
 		} else {
 			tally.debug("Keeping", rsfile.Name(), "in collection since I can't tell if it was removed")
 			newColl.UpdateFile(rsfile)
@@ -328,39 +575,54 @@ func (tally *tally) removeMissingFiles(directory string, oldColl, newColl RSColl
 	return ret
 }
 
-func (tally *tally) updateFile(collpath, fullpath string, coll RSCollection) (bool, error) {
-	tally.debug("Checking file", fullpath)
-	var ret, err = updateFile(coll, collpath, fullpath, tally.config.ForceUpdate)
-
-	if err != nil {
-		// Failure to update single file is not critical
-		tally.warn("Could not update", fullpath, err)
-		if !tally.config.IgnoreWarnings {
-			tally.warn("Stopping on warning")
-			return ret, err
-		}
-	}
-
-	return ret, nil
-}
-
+// Collection is written with writeFileAtomic, so fileTo is never left
+// half-written
 func (tally *tally) storeCollectionToFile(coll RSCollection, fileTo string) error {
-	var file, err = os.Create(fileTo)
-	if err != nil {
-		return tally.accessError(fileTo, "Cannot open for writing", err)
+	if tally.config.DryRun {
+		return tally.storePendingCollection(coll, fileTo)
 	}
-	err = coll.StoreTo(file)
-	var closeErr = file.Close()
+	var err = assertWritable(fileTo)
 	if err != nil {
-		return tally.accessError(fileTo, "Cannot save", err)
+		return tally.kindError(ErrWrite, fileTo, "Cannot open for writing", err)
+	}
+	var backupErr error
+	err = writeFileAtomic(fileTo, 0666, func(out io.Writer) error {
+		return tally.storeCollection(coll, out)
+	}, func() error {
+		backupErr = rotateBackups(fileTo, tally.config.CollectionBackups)
+		return backupErr
+	})
+	if backupErr != nil {
+		return tally.kindError(ErrWrite, fileTo, "Cannot make backup", backupErr)
 	}
-	if closeErr != nil {
-		return tally.accessError(fileTo, "Cannot close file", closeErr)
+	if err != nil {
+		return tally.kindError(ErrWrite, fileTo, "Cannot save", err)
 	}
 	tally.debug("Successfully saved collection to ", fileTo)
+	tally.progress.CollectionWritten(fileTo)
 	return nil
 }
 
+func (tally *tally) storeCollection(coll RSCollection, out io.Writer) error {
+	if tally.config.StrictRetroShareFormat {
+		return StoreStrict(coll, out)
+	}
+	return coll.StoreTo(out)
+}
+
+// Returns true if coll has timestamps StrictRetroShareFormat leaves out, so
+// collection written before it was turned on is rewritten even if no file
+// has changed
+func (tally *tally) hasTimestampsToStrip(coll RSCollection) bool {
+	var ret = false
+	if tally.config.StrictRetroShareFormat {
+		coll.Visit(func(file RSCollectionFile) {
+			ret = ret || !file.Timestamp().IsZero()
+		})
+	}
+	return ret
+}
+
 func (tally *tally) loadExistingCollection(fromFile string) (RSCollection, error) {
 	var stat, err = os.Stat(fromFile)
 	var fileExists bool
@@ -377,7 +639,7 @@ func (tally *tally) loadExistingCollection(fromFile string) (RSCollection, error
 	} else {
 		if !tally.isFile(stat) {
 			tally.debug("Path ", fromFile, " is not a regular file, failing")
-			return nil, tally.accessError(fromFile, "File is DIRECTORY and cannot be written to", nil)
+			return nil, tally.kindError(ErrWrite, fromFile, "File is DIRECTORY and cannot be written to", nil)
 		}
 		fileExists = true
 	}
@@ -395,15 +657,16 @@ func (tally *tally) loadExistingCollection(fromFile string) (RSCollection, error
 			tally.warn("Cannot load file", fromFile)
 			if !tally.config.IgnoreWarnings {
 				tally.err("Stopping on warning")
-				return nil, tally.accessError(fromFile, "Load error", err)
+				return nil, tally.kindError(ErrCollectionParse, fromFile, "Load error", err)
 			} else {
 				tally.warn("Using empty collection (will rehash files)")
+				tally.skipWarning(ErrCollectionParse, fromFile, "Load error", err)
 				coll.InitEmpty()
 			}
 
 		}
 		if closeErr != nil {
-			return nil, tally.accessError(fromFile, "Cannot close", err)
+			return nil, tally.accessError(fromFile, "Cannot close", closeErr)
 		}
 		tally.debug("Successfully loaded RSCollection from ", fromFile)
 	} else {
@@ -414,11 +677,12 @@ func (tally *tally) loadExistingCollection(fromFile string) (RSCollection, error
 }
 
 func (tally *tally) accessError(fullpath string, message string, cause error) error {
-	var ret = new(AccessError)
+	return tally.kindError(nil, fullpath, message, cause)
+}
 
-	ret.fullpath = fullpath
-	ret.message = message
-	ret.cause = cause
+// Same as accessError, but of known kind (one of Err* values)
+func (tally *tally) kindError(kind error, fullpath string, message string, cause error) error {
+	var ret = newAccessError(kind, fullpath, message, cause)
 
 	tally.err(ret)
 
@@ -465,29 +729,36 @@ func (tally *tally) isFile(file os.FileInfo) bool {
 }
 
 func (tally *tally) resolveCollectionFileForDirectory(directory string) (string, error) {
-	var ret, err = tally.resolveTemplate(tally.collectionPathnameTemplate, directory)
+	var settings, err = tally.settingsFor(directory)
+	if err != nil {
+		return "", err
+	}
+	var ret string
+	ret, err = tally.resolveTemplate(settings.pathnameTemplate, directory)
 	if err != nil {
 		return "", err
 	}
 	
 	if ret == "" {
-		var tplErr = new(ExpressionError)
-		tplErr.expression = tally.config.CollectionPathnameExpression
-		tplErr.message = "Evaluates to empty string"
+		var tplErr = newExpressionError(ErrTemplate, settings.pathnameExpression, "Evaluates to empty string", nil)
 		tally.err(tplErr)
 		return "", tplErr
 	}
 
-	if ret[0] == '/' {
-		tally.debug(ret, "is an absolute path")
-	} else {
-		ret = filepath.Join(filepath.Dir(directory), ret)
-	}
-
+	ret = tally.placeCollectionFile(directory, ret)
 	tally.debug("Resolved collection file for", directory, ":", ret)
 	return ret, nil
 }
 
+// Relative collection file name is next to directory
+func (tally *tally) placeCollectionFile(directory, name string) string {
+	if name[0] == '/' {
+		tally.debug(name, "is an absolute path")
+		return name
+	}
+	return filepath.Join(filepath.Dir(directory), name)
+}
+
 func (tally *tally) resolveTemplate(tpl *template.Template, directory string) (string, error) {
 	var context, err = tally.createEvaluationContext(directory)
 	if err != nil {
@@ -499,6 +770,11 @@ func (tally *tally) resolveTemplate(tpl *template.Template, directory string) (s
 type pathnameEvaluationContext struct {
 	path []string // Array of pathname components, for "/1/2/3" it should
                       // be {"1", "2", "3"}
+	relative  string // see RelativePath()
+	rootName  string
+	directory string
+	tally     *tally          // to collect directory stats, nil in tests
+	fixed     *directoryStats // used instead of collected stats if not nil
 }
 
 func (context *pathnameEvaluationContext) Path(idx int) string {
@@ -509,6 +785,48 @@ func (context *pathnameEvaluationContext) Path(idx int) string {
 	return context.path[arrIdx]
 }
 
+func (context *pathnameEvaluationContext) Depth() int {
+	if context.relative == "" {
+		return 0
+	}
+	var components = strings.Split(context.relative, "/")
+	if components[0] == ".." {
+		return -len(components)
+	}
+	return len(components)
+}
+
+func (context *pathnameEvaluationContext) RelativePath() string {
+	return context.relative
+}
+
+func (context *pathnameEvaluationContext) RootName() string {
+	return context.rootName
+}
+
+func (context *pathnameEvaluationContext) FileCount() int {
+	return context.stats().files
+}
+
+func (context *pathnameEvaluationContext) TotalSize() int64 {
+	return context.stats().size
+}
+
+func (context *pathnameEvaluationContext) NewestModTime() time.Time {
+	return context.stats().newest
+}
+
+// Collected on first use since walking the tree is expensive
+func (context *pathnameEvaluationContext) stats() *directoryStats {
+	if context.fixed != nil {
+		return context.fixed
+	}
+	if context.tally == nil {
+		return new(directoryStats)
+	}
+	return context.tally.directoryStats(context.directory)
+}
+
 func (tally *tally) ensureTemplatesCompiled() error {
 	if tally.collectionPathnameTemplate == nil {
 		var tpl, err = tally.compileTemplate(tally.config.CollectionPathnameExpression)
@@ -528,14 +846,11 @@ func (tally *tally) ensureTemplatesCompiled() error {
 }
 
 func (tally *tally) compileTemplate(expr string) (*template.Template, error) {
-	var tpl = template.New("template")
+	var tpl = template.New("template").Funcs(templateFuncs)
 	var err error
 	tpl, err = tpl.Parse(expr)
 	if err != nil {
-		var tplErr = new(ExpressionError)
-		tplErr.expression = expr
-		tplErr.message = "Cannot parse"
-		tplErr.cause = err
+		var tplErr = newExpressionError(ErrTemplate, expr, "Cannot parse", err)
 		tally.err(tplErr)
 		return nil, tplErr
 	}
@@ -549,6 +864,20 @@ func (tally *tally) createEvaluationContext(directory string) (TallyPathNameEval
 		return nil, tally.accessError(directory, "Cannot resolve absolute pathname", err)
 	}
 	ret.path = strings.Split(normalized, string(filepath.Separator))
+	ret.directory = filepath.Clean(directory)
+	ret.tally = tally
+
+	var root string
+	root, err = filepath.Abs(tally.root)
+	if err != nil {
+		return nil, tally.accessError(tally.root, "Cannot resolve absolute pathname", err)
+	}
+	ret.rootName = filepath.Base(root)
+	var relative string
+	relative, err = filepath.Rel(root, normalized)
+	if err == nil && relative != "." {
+		ret.relative = filepath.ToSlash(relative)
+	}
 	return ret, nil
 }
 
@@ -557,8 +886,7 @@ func (tally *tally) executeTemplate(tpl *template.Template, context TallyPathNam
 		if panicErr := recover(); panicErr != nil {
 			tally.err("Error executing template", panicErr)
 
-			var tplErr = new(ExpressionError)
-			tplErr.message = "Panic during evaluation"
+			var tplErr = newExpressionError(ErrTemplate, "", "Panic during evaluation", fmt.Errorf("%v", panicErr))
 			tally.err(tplErr)
 			ret = ""
 			err = tplErr
@@ -568,9 +896,7 @@ func (tally *tally) executeTemplate(tpl *template.Template, context TallyPathNam
 	var buf strings.Builder
 	err = tpl.Execute(io.Writer(&buf), context)
 	if err != nil {
-		var tplErr = new(ExpressionError)
-		tplErr.message = "Cannot evaluate"
-		tplErr.cause = err
+		var tplErr = newExpressionError(ErrTemplate, "", "Cannot evaluate", err)
 		tally.err(tplErr)
 		return "", tplErr
 	}
@@ -1,6 +1,8 @@
 package tallylib
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -431,6 +433,39 @@ func Test_UpdateSingleDirectory(t *testing.T) {
 	assertWillNotUpdateSingleDirectory(t, fixture, subdir)
 }
 
+func Test_UpdateSingleDirectory_will_keep_backups(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.CollectionBackups = 2
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateSingleDirectory_will_keep_backups")
+	defer os.RemoveAll(tmpdir)
+
+	subdir1 := mkdir(tmpdir, "subdir1")
+	subdir2 := mkdir(subdir1, "subdir2")
+	writefile(subdir2, "file1", "Hello, world!")
+	assertUpdateSingleDirectory(t, fixture, subdir2)
+	writefile(subdir2, "file2", "Hello 2")
+	assertUpdateSingleDirectory(t, fixture, subdir2)
+	writefile(subdir2, "file3", "Hello 3")
+	assertUpdateSingleDirectory(t, fixture, subdir2)
+
+	var collFile = resolveCollectionFileSimple(subdir2)
+	assertCollectionSize(t, 2, loadCollection(t, collFile+".bak"))
+	assertCollectionSize(t, 1, loadCollection(t, collFile+".bak.2"))
+	assertPathNotExists(t, filepath.Join(subdir1, ".subdir2.rscollection.tally-tmp"))
+
+	// Files of the user which only look like backups are kept
+	for _, name := range []string{"notes.txt", "notes.txt.bak", "data.db", "data.db.bak.2"} {
+		writefile(subdir1, name, name)
+	}
+	var coll = assertUpdateSingleDirectory(t, fixture, subdir1)
+	assertFileInCollection(t, coll, "subdir2.rscollection", "")
+	assertFileInCollection(t, coll, "notes.txt.bak", "")
+	assertFileInCollection(t, coll, "data.db.bak.2", "")
+	assertCollectionSize(t, 5, coll)
+}
+
 func Test_UpdateSingleDirectory_addChildren(t *testing.T) {
 	fixture := createFixture()
 	var config = fixture.GetConfig()
@@ -464,6 +499,135 @@ func Test_UpdateSingleDirectory_addChildren(t *testing.T) {
 	assertCollectionSize(t, 3, coll)
 }
 
+func Test_UpdateRecursive_will_skip_excluded(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.Exclude = []string{".DS_Store", "*.part", "!keep.part", ".git/", "/subdir2/skip"}
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateRecursive_will_skip_excluded")
+	defer os.RemoveAll(tmpdir)
+
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	writefile(subdir1, ".DS_Store", "junk")
+	writefile(subdir1, "download.part", "junk")
+	writefile(subdir1, "keep.part", "keep")
+	git := mkdir(subdir1, ".git")
+	writefile(git, "config", "junk")
+	subdir2 := mkdir(subdir1, "subdir2")
+	writefile(subdir2, "skip", "junk")
+	writefile(subdir2, "file2", "Hello 2")
+
+	var coll1 = assertUpdateRecursive(t, fixture, subdir1)
+	assertFileInCollection(t, coll1, "file1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertFileInCollection(t, coll1, "keep.part", "")
+	assertFileInCollection(t, coll1, "subdir2.rscollection", "")
+	assertCollectionSize(t, 3, coll1)
+	assertPathNotExists(t, filepath.Join(subdir1, ".git.rscollection"))
+
+	var coll2 = loadCollectionForDirectory(t, subdir2)
+	assertFileInCollection(t, coll2, "file2", "")
+	assertCollectionSize(t, 1, coll2)
+}
+
+func Test_UpdateRecursive_will_honor_tallyignore(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.Exclude = []string{"*.tmp"}
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateRecursive_will_honor_tallyignore")
+	defer os.RemoveAll(tmpdir)
+
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	writefile(subdir1, "notes.txt", "shared")
+	subdir2 := mkdir(subdir1, "subdir2")
+	writefile(subdir2, ".tallyignore", "# local rules\n*.txt\n/local/\n!keep.tmp\n")
+	writefile(subdir2, "notes.txt", "private")
+	writefile(subdir2, "keep.tmp", "keep")
+	writefile(subdir2, "drop.tmp", "drop")
+	local := mkdir(subdir2, "local")
+	writefile(local, "file", "private")
+	subdir3 := mkdir(subdir2, "subdir3")
+	writefile(subdir3, "more.txt", "private")
+	writefile(subdir3, "file3", "Hello 3")
+
+	var coll1 = assertUpdateRecursive(t, fixture, subdir1)
+	assertFileInCollection(t, coll1, "notes.txt", "")
+	assertCollectionSize(t, 3, coll1)
+
+	var coll2 = loadCollectionForDirectory(t, subdir2)
+	assertFileInCollection(t, coll2, "keep.tmp", "")
+	assertFileInCollection(t, coll2, "subdir3.rscollection", "")
+	assertCollectionSize(t, 2, coll2)
+	assertPathNotExists(t, resolveCollectionFileSimple(local))
+
+	var coll3 = loadCollectionForDirectory(t, subdir3)
+	assertFileInCollection(t, coll3, "file3", "")
+	assertCollectionSize(t, 1, coll3)
+}
+
+func Test_UpdateRecursive_will_honor_tallyignore_above_root(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_honor_tallyignore_above_root")
+	defer os.RemoveAll(tmpdir)
+	share := mkdir(tmpdir, "share")
+	writefile(share, ".tallyignore", "*.txt\n")
+	music := mkdir(share, "music")
+	writefile(music, "a.txt", "lyrics")
+	writefile(music, "b.mp3", "la-la-la")
+
+	var fixture = createFixture()
+	update(t, fixture, share, true)
+	if update(t, fixture, music, true) {
+		t.Error("patterns of share/.tallyignore must apply to music alone too")
+	}
+	var coll = loadCollectionForDirectory(t, music)
+	assertFileInCollection(t, coll, "b.mp3", "")
+	assertCollectionSize(t, 1, coll)
+
+	var config = fixture.GetConfig()
+	config.SettingsBoundary = music
+	fixture.SetConfig(config)
+	coll = assertUpdateRecursive(t, fixture, music)
+	assertFileInCollection(t, coll, "a.txt", "")
+	assertCollectionSize(t, 2, coll)
+}
+
+func Test_UpdateSingleDirectory_will_remove_excluded_and_keep_only_included(t *testing.T) {
+	fixture := createFixture()
+	tmpdir := mktmp("Test_UpdateSingleDirectory_will_remove_excluded_and_keep_only_included")
+	defer os.RemoveAll(tmpdir)
+
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "song.mp3", "la-la-la")
+	writefile(subdir1, "cover.jpg", "picture")
+	subdir2 := mkdir(subdir1, "subdir2")
+	writefile(subdir2, "other.mp3", "la-la")
+	writefile(subdir2, "Thumbs.db", "junk")
+	var coll = assertUpdateSingleDirectory(t, fixture, subdir1)
+	assertCollectionSize(t, 2, coll)
+
+	var config = fixture.GetConfig()
+	config.Include = []string{"*.mp3"}
+	fixture.SetConfig(config)
+	coll = assertUpdateSingleDirectory(t, fixture, subdir1)
+	assertFileInCollection(t, coll, "song.mp3", "")
+	assertCollectionSize(t, 1, coll)
+
+	var changed, err = fixture.UpdateRecursive(subdir1, 0, 1)
+	if err != nil || !changed {
+		t.Log("UpdateRecursive failed", changed, err)
+		t.Fail()
+	}
+	coll = loadCollectionForDirectory(t, subdir1)
+	assertFileInCollection(t, coll, "song.mp3", "")
+	assertFileInCollection(t, coll, "subdir2.rscollection", "")
+	assertCollectionSize(t, 2, coll)
+	coll = loadCollectionForDirectory(t, subdir2)
+	assertFileInCollection(t, coll, "other.mp3", "")
+	assertCollectionSize(t, 1, coll)
+}
+
 func Test_UpdateRecursive_will_fail_when_no_directory(t *testing.T) {
 	fixture := createFixture()
 	
@@ -821,6 +985,66 @@ func  Test_UpdateRecursive_will_stop_updating_parents_when_encounters_unreadable
 	assertShaSame(t, coll2File, sha2)
 }
 
+func Test_UpdateRecursiveContext_will_stop_when_cancelled(t *testing.T) {
+	var tmpdir = mktmp("Test_UpdateRecursiveContext_will_stop_when_cancelled")
+	defer os.RemoveAll(tmpdir)
+	var fixture = setup_9_directories(t, tmpdir)
+	var subdir1 = filepath.Join(tmpdir, "1")
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	var changed, err = fixture.UpdateRecursiveContext(ctx, subdir1, 0, -1)
+	if err != context.Canceled {
+		t.Log("Should return context.Canceled, but got", err)
+		t.Fail()
+	}
+	if changed {
+		t.Log("Should not change anything when cancelled before start")
+		t.Fail()
+	}
+	assertPathNotExists(t, resolveCollectionFileSimple(subdir1))
+}
+
+func Test_UpdateSingleDirectoryContext_will_keep_files_hashed_before_cancel(t *testing.T) {
+	var tmpdir = mktmp("Test_UpdateSingleDirectoryContext_will_keep_files_hashed_before_cancel")
+	defer os.RemoveAll(tmpdir)
+	var subdir = mkdir(tmpdir, "subdir")
+	for i := 1; i <= 20; i++ {
+		writefile(subdir, fmt.Sprintf("file%02d", i), fmt.Sprintf("Hello %d", i))
+	}
+	writefile(tmpdir, "subdir.rscollection", `<RsCollection><File sha1="old" name="file20" size="1"/></RsCollection>`)
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	var fixture = createFixture()
+	fixture.SetLog(&cancelOnLog{"Detected change in file01", cancel})
+
+	var changed, err = fixture.UpdateSingleDirectoryContext(ctx, subdir, false)
+	if err != context.Canceled {
+		t.Log("Should return context.Canceled, but got", err)
+		t.Fail()
+	}
+	if !changed {
+		t.Log("tally did not report a change")
+		t.Fail()
+	}
+	var coll = loadCollectionForDirectory(t, subdir)
+	assertFileInCollection(t, coll, "file01", "1c2547eeb73bbd323200f89e415f583b1932542a")
+	assertFileInCollection(t, coll, "file20", "old")
+}
+
+// Cancels context when log message containing text is written
+type cancelOnLog struct {
+	text   string
+	cancel context.CancelFunc
+}
+
+func (w *cancelOnLog) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), w.text) {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
 
 func setup_9_directories(t *testing.T, tmpdir string) Tally {
 	fixture := createFixture()
@@ -1113,3 +1337,55 @@ func mockEvaluationContext (path string) TallyPathNameEvalutationContext {
 	ret.path = strings.Split(path, string(filepath.Separator))
 	return ret
 }
+
+func Test_UpdateRecursive_in_strict_format_will_use_hash_cache(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_in_strict_format_will_use_hash_cache")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.StrictRetroShareFormat = true
+	config.HashCacheDir = filepath.Join(tmpdir, "cache")
+	fixture.SetConfig(config)
+
+	var coll = assertUpdateRecursive(t, fixture, subdir1)
+	assertFileInCollection(t, coll, "file1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	var data, _ = ioutil.ReadFile(resolveCollectionFileSimple(subdir1))
+	if strings.Contains(string(data), "updated") {
+		t.Error("strict collection has non-standard attributes:", string(data))
+	}
+
+	var listener = new(recordingListener)
+	fixture.SetProgressListener(listener)
+	assertWillNotUpdateRecursive(t, fixture, subdir1)
+	assertIntEquals(t, "hashes started", 0, listener.hashesStarted)
+}
+
+func Test_UpdateRecursive_will_strip_timestamps_when_switching_to_strict_format(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_strip_timestamps_when_switching_to_strict_format")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	big := mkdir(subdir1, "big")
+	writefile(big, "file2", "Hello 2")
+	writefile(big, "file3", "Hello 3")
+	writefile(big, "file4", "Hello 4")
+	writefile(big, "file5", "Hello 5")
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.MaxCollectionEntries = 3
+	fixture.SetConfig(config)
+	assertUpdateRecursive(t, fixture, subdir1)
+
+	config.StrictRetroShareFormat = true
+	fixture.SetConfig(config)
+	assertUpdateRecursive(t, fixture, subdir1)
+	for _, file := range []string{resolveCollectionFileSimple(subdir1), partFile(resolveCollectionFileSimple(big), 1)} {
+		var data, _ = ioutil.ReadFile(file)
+		if !strings.Contains(string(data), "<File ") || strings.Contains(string(data), "updated") {
+			t.Error(file, "is not in strict format:", string(data))
+		}
+	}
+	assertWillNotUpdateRecursive(t, fixture, subdir1)
+}
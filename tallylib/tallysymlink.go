@@ -0,0 +1,94 @@
+package tallylib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Replaces symlinks in directory listing with what they point to, see
+// TallyConfig.FollowSymlinks. Links that can't be followed are left as is
+// and then skipped as non-regular files
+func (tally *tally) resolveSymlinks(directory string, files []os.FileInfo) []os.FileInfo {
+	if !tally.config.FollowSymlinks {
+		return files
+	}
+	for i, file := range files {
+		if file.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		var fullpath = filepath.Join(directory, file.Name())
+		var target, err = os.Stat(fullpath)
+		if err != nil {
+			tally.warn("Cannot follow symlink", fullpath, err)
+			continue
+		}
+		if tally.config.SymlinksWithinRoot && !tally.isWithinRoot(fullpath) {
+			tally.info("Not following symlink", fullpath, "since it points outside of", tally.realRoot)
+			continue
+		}
+		tally.debug("Following symlink", fullpath)
+		files[i] = target
+	}
+	return files
+}
+
+func (tally *tally) isWithinRoot(fullpath string) bool {
+	var target, err = filepath.EvalSymlinks(fullpath)
+	if err == nil {
+		target, err = filepath.Abs(target)
+	}
+	if err != nil {
+		tally.debug("Cannot resolve", fullpath, err)
+		return false
+	}
+	var rel string
+	rel, err = filepath.Rel(tally.realRoot, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Remembers where symlinks are allowed to point to and starts tracking
+// directories being processed
+func (tally *tally) initSymlinks(root string) {
+	tally.ancestors = nil
+	tally.realRoot = ""
+	if !tally.config.FollowSymlinks {
+		return
+	}
+	var stat, err = os.Stat(root)
+	if err == nil {
+		tally.ancestors = append(tally.ancestors, stat)
+	}
+	if tally.config.SymlinksWithinRoot {
+		var realRoot, err = filepath.EvalSymlinks(root)
+		if err == nil {
+			realRoot, err = filepath.Abs(realRoot)
+		}
+		if err == nil {
+			tally.realRoot = realRoot
+		}
+	}
+}
+
+// Must be called before descending into a subdirectory. Returns false if
+// directory is already being processed, i.e. symlinks form a cycle.
+// Every successful enterDirectory must be paired with leaveDirectory
+func (tally *tally) enterDirectory(fullpath string, stat os.FileInfo) bool {
+	if !tally.config.FollowSymlinks {
+		return true
+	}
+	for _, ancestor := range tally.ancestors {
+		if os.SameFile(ancestor, stat) {
+			tally.warn("Skipping", fullpath, "since symlinks form a cycle")
+			return false
+		}
+	}
+	tally.ancestors = append(tally.ancestors, stat)
+	return true
+}
+
+func (tally *tally) leaveDirectory() {
+	if tally.config.FollowSymlinks {
+		tally.ancestors = tally.ancestors[:len(tally.ancestors)-1]
+	}
+}
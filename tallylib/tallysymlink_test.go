@@ -0,0 +1,97 @@
+package tallylib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_UpdateRecursive_will_skip_symlinks_by_default(t *testing.T) {
+	fixture := createFixture()
+	tmpdir := mktmp("Test_UpdateRecursive_will_skip_symlinks_by_default")
+	defer os.RemoveAll(tmpdir)
+	subdir1, _ := setup_symlinks(t, tmpdir)
+
+	var coll1 = assertUpdateRecursive(t, fixture, subdir1)
+	assertFileInCollection(t, coll1, "file1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertCollectionSize(t, 1, coll1)
+	assertPathNotExists(t, filepath.Join(subdir1, "linked.rscollection"))
+}
+
+func Test_UpdateRecursive_will_follow_symlinks_and_skip_cycles(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.FollowSymlinks = true
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateRecursive_will_follow_symlinks_and_skip_cycles")
+	defer os.RemoveAll(tmpdir)
+	subdir1, _ := setup_symlinks(t, tmpdir)
+
+	var coll1 = assertUpdateRecursive(t, fixture, subdir1)
+	assertFileInCollection(t, coll1, "file1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertFileInCollection(t, coll1, "alias", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertFileInCollection(t, coll1, "linked.rscollection", "")
+	assertCollectionSize(t, 3, coll1)
+	assertPathNotExists(t, filepath.Join(subdir1, "loop.rscollection"))
+
+	var linked = loadCollectionForDirectory(t, filepath.Join(subdir1, "linked"))
+	assertFileInCollection(t, linked, "file2", "")
+	assertCollectionSize(t, 1, linked)
+}
+
+func Test_UpdateSingleDirectory_will_skip_nested_symlink_cycles(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.FollowSymlinks = true
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateSingleDirectory_will_skip_nested_symlink_cycles")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	inner := mkdir(subdir1, "inner")
+	writefile(inner, "file3", "Hello 3")
+	symlink(t, subdir1, filepath.Join(inner, "back"))
+
+	var changed, err = fixture.UpdateSingleDirectory(subdir1, true)
+	if err != nil || !changed {
+		t.Fatal("Cannot update", changed, err)
+	}
+	var coll = loadCollectionForDirectory(t, subdir1)
+	assertFileInCollection(t, coll, "inner/file3", "")
+	assertCollectionSize(t, 1, coll)
+}
+
+func Test_UpdateRecursive_will_not_follow_symlinks_outside_root(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.FollowSymlinks = true
+	config.SymlinksWithinRoot = true
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateRecursive_will_not_follow_symlinks_outside_root")
+	defer os.RemoveAll(tmpdir)
+	subdir1, _ := setup_symlinks(t, tmpdir)
+
+	var coll1 = assertUpdateRecursive(t, fixture, subdir1)
+	assertFileInCollection(t, coll1, "file1", "")
+	assertFileInCollection(t, coll1, "alias", "")
+	assertCollectionSize(t, 2, coll1)
+	assertPathNotExists(t, filepath.Join(subdir1, "linked.rscollection"))
+}
+
+// subdir1 has file1, link alias -> file1, link loop -> subdir1 and link
+// linked -> outside directory with file2
+func setup_symlinks(t *testing.T, tmpdir string) (subdir1, outside string) {
+	subdir1 = mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	outside = mkdir(tmpdir, "outside")
+	writefile(outside, "file2", "Hello 2")
+	symlink(t, filepath.Join(subdir1, "file1"), filepath.Join(subdir1, "alias"))
+	symlink(t, subdir1, filepath.Join(subdir1, "loop"))
+	symlink(t, outside, filepath.Join(subdir1, "linked"))
+	return
+}
+
+func symlink(t *testing.T, target, link string) {
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("Symlinks are not supported", err)
+	}
+}
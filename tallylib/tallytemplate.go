@@ -0,0 +1,357 @@
+package tallylib
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Functions available in collection expressions. Like in text/template
+// builtins, value being transformed goes last, so functions work in
+// pipelines: {{.Path 0 | replace " " "_" | lower}}
+var templateFuncs = template.FuncMap{
+	"lower":        strings.ToLower,
+	"upper":        strings.ToUpper,
+	"title":        title,
+	"replace":      replace,
+	"regexReplace": regexReplace,
+	"trim":         trim,
+	"truncate":     truncate,
+	"join":         join,
+	"default":      defaultString,
+	"slug":         slug,
+	"humanSize":    HumanSize,
+}
+
+// Upper-cases first letter of every word
+func title(s string) string {
+	var ret strings.Builder
+	var wordStart = true
+	for _, r := range s {
+		if wordStart {
+			ret.WriteRune(unicode.ToUpper(r))
+		} else {
+			ret.WriteRune(r)
+		}
+		wordStart = !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}
+	return ret.String()
+}
+
+func replace(old, new, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+// Replacement can refer to groups as $1 or ${name}, see regexp.Expand
+func regexReplace(pattern, replacement, s string) (string, error) {
+	var re, err = regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, replacement), nil
+}
+
+// trim s removes leading and trailing spaces, trim cutset s removes
+// leading and trailing characters found in cutset
+func trim(args ...string) (string, error) {
+	switch len(args) {
+	case 1:
+		return strings.TrimSpace(args[0]), nil
+	case 2:
+		return strings.Trim(args[1], args[0]), nil
+	}
+	return "", errors.New("trim expects 1 or 2 arguments")
+}
+
+// Keeps at most n first characters (not bytes)
+func truncate(n int, s string) string {
+	if n < 0 {
+		n = 0
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// Joins non-empty elements with separator
+func join(separator string, elements ...string) string {
+	var nonEmpty []string
+	for _, element := range elements {
+		if element != "" {
+			nonEmpty = append(nonEmpty, element)
+		}
+	}
+	return strings.Join(nonEmpty, separator)
+}
+
+func defaultString(def, s string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// Lower-cases letters and digits and replaces everything else with single
+// dashes, "Depeche Mode: Violator!" becomes "depeche-mode-violator"
+func slug(s string) string {
+	var ret strings.Builder
+	var dash = false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && ret.Len() > 0 {
+				ret.WriteByte('-')
+			}
+			dash = false
+			ret.WriteRune(unicode.ToLower(r))
+		} else {
+			dash = true
+		}
+	}
+	return ret.String()
+}
+
+// Formats byte count the way file managers do: "512B", "1.5KB", "540MB".
+// Same as humanSize function of collection expressions
+func HumanSize(size int64) string {
+	var units = []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	var value = float64(size)
+	var unit = 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit > 0 && value < 10 {
+		return strconv.FormatFloat(value, 'f', 1, 64) + units[unit]
+	}
+	return strconv.FormatFloat(value, 'f', 0, 64) + units[unit]
+}
+
+// Files which go into collection of a directory, see
+// TallyPathNameEvalutationContext.FileCount
+type directoryStats struct {
+	files  int
+	size   int64
+	newest time.Time
+}
+
+func (stats *directoryStats) add(other *directoryStats) {
+	stats.files += other.files
+	stats.size += other.size
+	if other.newest.After(stats.newest) {
+		stats.newest = other.newest
+	}
+}
+
+func (stats *directoryStats) addFile(file os.FileInfo) {
+	stats.add(&directoryStats{files: 1, size: file.Size(), newest: file.ModTime()})
+}
+
+// Walks directory tree the same way update does. Results are cached for
+// the rest of Update* call, so collections of subdirectories written in
+// the meantime do not change them
+func (tally *tally) directoryStats(directory string) *directoryStats {
+	var ret = tally.stats[directory]
+	if ret != nil {
+		return ret
+	}
+	ret = new(directoryStats)
+	var stat, err = os.Stat(directory)
+	if err != nil {
+		return ret
+	}
+	// Collections of subdirectories are resolved below, which may walk
+	// the same directories again if they are reachable through symlinks
+	for _, walked := range tally.statsWalk {
+		if os.SameFile(walked, stat) {
+			return ret
+		}
+	}
+	tally.statsWalk = append(tally.statsWalk, stat)
+	defer func() {
+		tally.statsWalk = tally.statsWalk[:len(tally.statsWalk)-1]
+	}()
+
+	var files []os.FileInfo
+	files, err = tally.listDirectory(directory)
+	if err != nil {
+		return ret
+	}
+	var collections = tally.subdirectoryCollections(directory, files)
+	var previous = tally.previousSubdirectoryCollections(directory, files, collections)
+	for _, file := range files {
+		var fullpath = filepath.Join(directory, file.Name())
+		if tally.isDir(file) {
+			ret.add(tally.directoryStats(fullpath))
+		} else if tally.isFile(file) && !collections[fullpath] && !previous[fullpath] {
+			ret.addFile(file)
+		}
+	}
+	if tally.stats != nil {
+		tally.stats[directory] = ret
+	}
+	return ret
+}
+
+// Collection file names CollectionPathnameExpression gives to directory
+// with any stats. When expression uses directory stats, collection gets
+// new name whenever directory contents change, so the file written under
+// previous name has to be removed. Only the part of the name before and
+// after stats is known, nil pattern means name does not depend on stats
+type collectionNamePattern struct {
+	directory string // where collection files are
+	prefix    string
+	suffix    string
+}
+
+// Stats far apart enough for any expression to give different names
+var patternStats = [2]directoryStats{
+	{files: 1, size: 1, newest: time.Date(2001, 1, 1, 1, 1, 1, 0, time.UTC)},
+	{files: 987654, size: 987654321098, newest: time.Date(2099, 12, 31, 23, 59, 59, 0, time.UTC)},
+}
+
+func (tally *tally) collectionNamePattern(directory string) (*collectionNamePattern, error) {
+	var settings, err = tally.settingsFor(directory)
+	if err != nil {
+		return nil, err
+	}
+	var names [2]string
+	for i := range patternStats {
+		var context TallyPathNameEvalutationContext
+		context, err = tally.createEvaluationContext(directory)
+		if err != nil {
+			return nil, err
+		}
+		context.(*pathnameEvaluationContext).fixed = &patternStats[i]
+		names[i], err = tally.executeTemplate(settings.pathnameTemplate, context)
+		if err != nil {
+			return nil, err
+		}
+		if names[i] == "" {
+			return nil, nil
+		}
+		names[i] = tally.placeCollectionFile(directory, names[i])
+	}
+	if names[0] == names[1] || filepath.Dir(names[0]) != filepath.Dir(names[1]) {
+		return nil, nil
+	}
+
+	var ret = &collectionNamePattern{directory: filepath.Dir(names[0])}
+	var a, b = filepath.Base(names[0]), filepath.Base(names[1])
+	var n = 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	ret.prefix, a, b = a[:n], a[n:], b[n:]
+	n = 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	ret.suffix = a[len(a)-n:]
+	return ret, nil
+}
+
+// Returns true if path (or collection it is a part of) is named by pattern
+func (pattern *collectionNamePattern) matches(path string) bool {
+	if whole, n := partOf(path); n > 0 {
+		path = whole
+	}
+	var name = filepath.Base(path)
+	return filepath.Dir(path) == pattern.directory &&
+		len(name) > len(pattern.prefix)+len(pattern.suffix) &&
+		strings.HasPrefix(name, pattern.prefix) && strings.HasSuffix(name, pattern.suffix)
+}
+
+// Returns collection files (and their parts) written for directory under
+// names it had before, see collectionNamePattern. Collection files of
+// other directories and files which are not collections are left alone
+func (tally *tally) previousCollections(directory, collectionFile string) (map[string]RSCollection, error) {
+	var pattern, err = tally.collectionNamePattern(directory)
+	if pattern == nil || err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	files, err = ioutil.ReadDir(pattern.directory)
+	if err != nil {
+		tally.debug("Cannot list", pattern.directory, "for previous collections of", directory, err)
+		return nil, nil
+	}
+	var current = tally.subdirectoryCollections(pattern.directory, files)
+	var ret = make(map[string]RSCollection)
+	for _, file := range files {
+		var path = filepath.Join(pattern.directory, file.Name())
+		if !tally.isFile(file) || path == collectionFile || partNumber(collectionFile, path) > 0 ||
+			current[path] || tally.removed[path] || !pattern.matches(path) {
+			continue
+		}
+		var coll = tryLoadCollectionFile(path)
+		if coll == nil {
+			tally.debug("Not removing", path, "since it is not a collection")
+			continue
+		}
+		ret[path] = coll
+	}
+	return ret, nil
+}
+
+// Removes collection files returned by previousCollections
+func (tally *tally) removePreviousCollections(changed bool, previous map[string]RSCollection) (bool, error) {
+	var paths = make([]string, 0, len(previous))
+	for path := range previous {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var err = tally.removeCollectionFile(path, collectionSha1s(previous[path]))
+		if err != nil {
+			return true, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// Files among files of directory which are collections of its
+// subdirectories written under previous names, see previousCollections.
+// They are not counted in directory stats even before they are removed
+func (tally *tally) previousSubdirectoryCollections(directory string, files []os.FileInfo, collections map[string]bool) map[string]bool {
+	var ret = make(map[string]bool)
+	for _, dir := range files {
+		if !tally.isDir(dir) {
+			continue
+		}
+		var pattern, err = tally.collectionNamePattern(filepath.Join(directory, dir.Name()))
+		if pattern == nil || err != nil || pattern.directory != directory {
+			continue
+		}
+		for _, file := range files {
+			var path = filepath.Join(directory, file.Name())
+			if tally.isFile(file) && !collections[path] && pattern.matches(path) {
+				ret[path] = true
+			}
+		}
+	}
+	return ret
+}
+
+func tryLoadCollectionFile(path string) RSCollection {
+	var file, err = os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var ret = NewCollection()
+	if ret.LoadFrom(file) != nil {
+		return nil
+	}
+	return ret
+}
@@ -0,0 +1,154 @@
+package tallylib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_templateFuncs_will_transform_path(t *testing.T) {
+	fixture := createFixture().(*tally)
+	var context = &pathnameEvaluationContext{path: []string{"", "music", "Depeche Mode", " 1990_Violator!"}}
+	var cases = map[string]string{
+		"{{.Path 0 | lower}}":                                   " 1990_violator!",
+		"{{.Path -1 | upper}}":                                  "DEPECHE MODE",
+		"{{.Path -2 | title}}":                                  "Music",
+		"{{.Path -1 | replace \" \" \"_\"}}":                    "Depeche_Mode",
+		"{{.Path 0 | trim | regexReplace \"^[0-9]{4}_\" \"\"}}": "Violator!",
+		"{{.Path 0 | trim \" !\"}}":                             "1990_Violator",
+		"{{.Path -1 | truncate 7}}":                             "Depeche",
+		"{{join \"-\" (.Path -3) (.Path -1)}}":                  "Depeche Mode",
+		"{{.Path -4 | default \"none\"}}":                       "none",
+		"{{.Path -1 | default \"none\"}}":                       "Depeche Mode",
+		"{{.Path -1 | slug}}-{{.Path 0 | slug}}":                "depeche-mode-1990-violator",
+	}
+	for expr, expected := range cases {
+		var tpl, err = fixture.compileTemplate(expr)
+		if err != nil {
+			t.Error(expr, err)
+			continue
+		}
+		var actual string
+		actual, err = fixture.executeTemplate(tpl, context)
+		if err != nil {
+			t.Error(expr, err)
+			continue
+		}
+		if actual != expected {
+			t.Error(expr, "expected:", expected, "actual:", actual)
+		}
+	}
+}
+
+func Test_templateFuncs_will_report_invalid_regexp(t *testing.T) {
+	fixture := createFixture().(*tally)
+	var tpl, err = fixture.compileTemplate("{{.Path 0 | regexReplace \"(\" \"\"}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fixture.executeTemplate(tpl, &pathnameEvaluationContext{path: []string{"dir"}})
+	assertErrorKind(t, ErrTemplate, err)
+}
+
+func Test_UpdateSingleDirectory_with_template_functions(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.CollectionPathnameExpression = "{{.Path 0 | slug}}.rscollection"
+	config.CollectionRootPathExpression = "{{.Path 0 | upper}}"
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_UpdateSingleDirectory_with_template_functions")
+	defer os.RemoveAll(tmpdir)
+
+	subdir := mkdir(tmpdir, "My Album")
+	writefile(subdir, "file1", "Hello, world!")
+	update(t, fixture, subdir, true)
+
+	var coll = loadCollection(t, filepath.Join(tmpdir, "my-album.rscollection"))
+	assertFileInCollection(t, coll, "MY ALBUM/file1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+}
+
+func Test_HumanSize(t *testing.T) {
+	assertStringEquals(t, "0B", HumanSize(0))
+	assertStringEquals(t, "1023B", HumanSize(1023))
+	assertStringEquals(t, "1.5KB", HumanSize(1536))
+	assertStringEquals(t, "540MB", HumanSize(540*1024*1024))
+}
+
+func Test_UpdateRecursive_will_evaluate_directory_stats(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_evaluate_directory_stats")
+	defer os.RemoveAll(tmpdir)
+	root := mkdir(tmpdir, "root")
+	subdirA := mkdir(root, "a")
+	subdirB := mkdir(subdirA, "b")
+	setModTime(writefile(root, "file0", "Hello, world!"), 1999)
+	setModTime(writefile(subdirA, "file1", "Hello, world!"), 2005)
+	setModTime(writefile(subdirB, "file2", "Hello, world!"), 2001)
+
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.CollectionPathnameExpression = "{{.RootName}}_{{.Depth}}_{{.RelativePath | replace \"/\" \"_\"}}_{{.FileCount}}_{{.TotalSize}}.rscollection"
+	config.CollectionRootPathExpression = "{{.NewestModTime.Year}}"
+	fixture.SetConfig(config)
+	var _, err = fixture.UpdateRecursive(root, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var coll = loadCollection(t, filepath.Join(subdirA, "root_2_a_b_1_13.rscollection"))
+	assertFileInCollection(t, coll, "2001/file2", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	coll = loadCollection(t, filepath.Join(root, "root_1_a_2_26.rscollection"))
+	assertFileInCollection(t, coll, "2005/file1", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertCollectionSize(t, 2, coll)
+	coll = loadCollection(t, filepath.Join(tmpdir, "root_0__3_39.rscollection"))
+	assertFileInCollection(t, coll, "2005/file0", "943a702d06f34599aee1f8da8ef9f7296031d699")
+	assertCollectionSize(t, 2, coll)
+}
+
+func Test_UpdateRecursive_will_remove_collection_written_under_previous_name(t *testing.T) {
+	tmpdir := mktmp("Test_UpdateRecursive_will_remove_collection_written_under_previous_name")
+	defer os.RemoveAll(tmpdir)
+	music := mkdir(tmpdir, "music")
+	album := mkdir(music, "Album")
+	writefile(album, "track1", "Hello, world!")
+	writefile(mkdir(music, "Album (Deluxe)"), "track1", "Hello, world!")
+
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.CollectionPathnameExpression = "{{.Path 0}} ({{.FileCount}} files).rscollection"
+	fixture.SetConfig(config)
+	update(t, fixture, music, true)
+	assertCollectionSize(t, 2, loadCollection(t, filepath.Join(tmpdir, "music (2 files).rscollection")))
+
+	writefile(album, "track2", "Hello 2")
+	var listener = new(recordingListener)
+	fixture.SetProgressListener(listener)
+	update(t, fixture, music, true)
+	// track2 and Album collection under its new name, track1 is not rehashed
+	assertIntEquals(t, "hashes started", 2, listener.hashesStarted)
+	assertPathNotExists(t, filepath.Join(music, "Album (1 files).rscollection"))
+	assertCollectionSize(t, 2, loadCollection(t, filepath.Join(music, "Album (2 files).rscollection")))
+	assertCollectionSize(t, 1, loadCollection(t, filepath.Join(music, "Album (Deluxe) (1 files).rscollection")))
+	assertPathNotExists(t, filepath.Join(tmpdir, "music (2 files).rscollection"))
+	var coll = loadCollection(t, filepath.Join(tmpdir, "music (3 files).rscollection"))
+	assertFileInCollection(t, coll, "Album (2 files).rscollection", "")
+	assertFileInCollection(t, coll, "Album (Deluxe) (1 files).rscollection", "")
+	assertCollectionSize(t, 2, coll)
+
+	config.DryRun = true
+	fixture.SetConfig(config)
+	writefile(album, "track3", "Hello 3")
+	update(t, fixture, music, true)
+	var plan = fixture.GetPlan()
+	var deleted = findPlannedCollection(t, plan, filepath.Join(music, "Album (2 files).rscollection"))
+	if !deleted.Deleted {
+		t.Error("collection under previous name should be planned as deleted")
+	}
+	findPlannedCollection(t, plan, filepath.Join(tmpdir, "music (4 files).rscollection"))
+	loadCollection(t, filepath.Join(music, "Album (2 files).rscollection"))
+}
+
+func setModTime(path string, year int) {
+	var modTime = time.Date(year, 6, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(path, modTime, modTime)
+}
@@ -0,0 +1,165 @@
+package tallylib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func (tally *tally) Verify(directory string) (VerifyReport, error) {
+	return tally.VerifyContext(context.Background(), directory)
+}
+
+func (tally *tally) VerifyContext(ctx context.Context, directory string) (VerifyReport, error) {
+	var report VerifyReport
+	defer tally.end()
+	var err = tally.begin(ctx, directory)
+	if err != nil {
+		return report, err
+	}
+	// Verify never trusts cached hashes and never modifies anything
+	tally.cache = nil
+
+	var normalizedPath = filepath.Clean(directory)
+	tally.info("Verify(", normalizedPath, ")")
+	err = tally.assertDirectory(normalizedPath)
+	if err == nil {
+		err = tally.verifyRecursive(normalizedPath, &report)
+	}
+	return report, err
+}
+
+func (tally *tally) verifyRecursive(directory string, report *VerifyReport) error {
+	tally.progress.DirectoryEntered(directory)
+	var err = tally.verifyDirectory(directory, report)
+	if err != nil {
+		return err
+	}
+
+	var files []os.FileInfo
+	files, err = tally.listDirectory(directory)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = tally.ctx.Err(); err != nil {
+			return err
+		}
+		if !tally.isDir(file) {
+			continue
+		}
+		var fullpath = filepath.Join(directory, file.Name())
+		if !tally.enterDirectory(fullpath, file) {
+			continue
+		}
+		err = tally.verifyRecursive(fullpath, report)
+		tally.leaveDirectory()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verifies collection of the directory, if there is one
+func (tally *tally) verifyDirectory(directory string, report *VerifyReport) error {
+	var collectionFile, err = tally.resolveCollectionFileForDirectory(directory)
+	if err != nil {
+		return err
+	}
+	// Split collection is verified part by part
+	var files = tally.collectionFiles(collectionFile)
+	if len(files) == 0 {
+		tally.debug("No collection", collectionFile, "to verify")
+		return nil
+	}
+	var root string
+	root, err = tally.resolveCollectionRootPathForDirectory(directory)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = tally.verifyCollectionFile(directory, root, file, report)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tally *tally) verifyCollectionFile(directory, root, collectionFile string, report *VerifyReport) error {
+	report.Collections++
+	var coll, err = tally.loadExistingCollection(collectionFile)
+	if err != nil {
+		report.Problems = append(report.Problems, VerifyProblem{
+			Kind:           VerifyUnreadable,
+			CollectionFile: collectionFile,
+			Err:            err,
+		})
+		return nil
+	}
+
+	var names = make([]string, 0, coll.Size())
+	coll.Visit(func(entry RSCollectionFile) {
+		names = append(names, entry.Name())
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		var problem = tally.verifyEntry(directory, root, coll.ByName(name))
+		if err = tally.ctx.Err(); err != nil {
+			return err
+		}
+		if problem == nil || problem.Kind == VerifyMismatch {
+			report.Files++
+		}
+		if problem != nil {
+			problem.CollectionFile = collectionFile
+			if problem.Err != nil {
+				tally.warn("Verify:", problem.Name, "in", collectionFile, "is", problem.Kind, problem.Err)
+			} else {
+				tally.warn("Verify:", problem.Name, "in", collectionFile, "is", problem.Kind)
+			}
+			report.Problems = append(report.Problems, *problem)
+		}
+	}
+	return nil
+}
+
+// Returns nil if entry matches the file
+func (tally *tally) verifyEntry(directory, root string, entry RSCollectionFile) *VerifyProblem {
+	var problem = new(VerifyProblem)
+	problem.Name = entry.Name()
+	problem.Expected = entry.Sha1()
+
+	var rel = entry.Name()
+	if root != "" {
+		if !strings.HasPrefix(rel, root+"/") {
+			problem.Kind = VerifyMissing
+			return problem
+		}
+		rel = rel[len(root)+1:]
+	}
+	problem.Fullpath = filepath.Join(directory, filepath.FromSlash(rel))
+
+	var actual, err = hashFileIfChanged(tally.ctx, tally.progress, nil, entry.Name(), problem.Fullpath, nil)
+	if os.IsNotExist(err) {
+		problem.Kind = VerifyMissing
+		problem.Err = err
+		return problem
+	}
+	if err != nil {
+		problem.Kind = VerifyUnreadable
+		problem.Err = err
+		return problem
+	}
+	if actual.Sha1() != entry.Sha1() {
+		problem.Kind = VerifyMismatch
+		problem.Actual = actual.Sha1()
+		return problem
+	}
+	tally.debug("Verified", problem.Fullpath)
+	return nil
+}
@@ -0,0 +1,94 @@
+package tallylib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Verify_will_report_problems_without_modifying_anything(t *testing.T) {
+	fixture := createFixture()
+	tmpdir := mktmp("Test_Verify_will_report_problems_without_modifying_anything")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	corrupted := writefile(subdir1, "corrupted", "Hello 1")
+	subdir2 := mkdir(subdir1, "subdir2")
+	removed := writefile(subdir2, "removed", "Hello 2")
+	unreadable := writefile(subdir2, "unreadable", "Hello 3")
+	assertUpdateRecursive(t, fixture, subdir1)
+
+	// Same size and timestamp, so regular update would not notice
+	var stat, _ = os.Stat(corrupted)
+	writefile(subdir1, "corrupted", "Hello 9")
+	os.Chtimes(corrupted, stat.ModTime(), stat.ModTime())
+	os.Remove(removed)
+	os.Chmod(unreadable, 0)
+	var collectionFile = resolveCollectionFileSimple(subdir1)
+	var timestamp = getTimestampSafe(collectionFile)
+
+	var report, err = fixture.Verify(subdir1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIntEquals(t, "collections", 2, report.Collections)
+	assertIntEquals(t, "files", 3, report.Files)
+	assertIntEquals(t, "problems", 3, len(report.Problems))
+	assertVerifyProblem(t, report, VerifyMismatch, corrupted)
+	assertVerifyProblem(t, report, VerifyMissing, removed)
+	assertVerifyProblem(t, report, VerifyUnreadable, unreadable)
+	if !getTimestampSafe(collectionFile).Equal(timestamp) {
+		t.Error("Verify must not touch collections")
+	}
+}
+
+func Test_Verify_will_pass_fresh_tree_with_root_path(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.CollectionRootPathExpression = "{{.Path -1}}/{{.Path 0}}"
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_Verify_will_pass_fresh_tree_with_root_path")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	writefile(mkdir(subdir1, "subdir2"), "file2", "Hello 2")
+	assertUpdateRecursive(t, fixture, subdir1)
+
+	var report, err = fixture.Verify(subdir1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIntEquals(t, "collections", 2, report.Collections)
+	assertIntEquals(t, "files", 3, report.Files)
+	assertIntEquals(t, "problems", 0, len(report.Problems))
+}
+
+func Test_VerifyContext_will_stop_when_cancelled(t *testing.T) {
+	fixture := createFixture()
+	tmpdir := mktmp("Test_VerifyContext_will_stop_when_cancelled")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	assertUpdateRecursive(t, fixture, subdir1)
+
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	cancel()
+	var _, err = fixture.VerifyContext(ctx, subdir1)
+	if err != context.Canceled {
+		t.Error("expected context.Canceled, got", err)
+	}
+}
+
+func assertVerifyProblem(t *testing.T, report VerifyReport, kind VerifyProblemKind, fullpath string) {
+	for _, problem := range report.Problems {
+		if filepath.Clean(problem.Fullpath) == filepath.Clean(fullpath) {
+			if problem.Kind != kind {
+				t.Error(fullpath, "expected to be", kind, "but it is", problem.Kind)
+			}
+			return
+		}
+	}
+	t.Error("no problem reported for", fullpath)
+}
@@ -0,0 +1,315 @@
+package tallylib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Source of file system change notifications, see newDirWatcher
+type dirWatcher interface {
+	// Start watching single directory (not its subdirectories)
+	add(directory string) error
+
+	// Channel is closed once watcher is closed
+	events() <-chan watchEvent
+
+	close() error
+}
+
+type watchEvent struct {
+	directory string // watched directory where something changed
+	name      string // entry in directory which changed
+	isDir     bool   // entry is a directory
+	overflow  bool   // events were lost, directory and name are empty
+}
+
+// Watch updates collections of changed directories no sooner than this
+// many debounce intervals after the first change, even if changes keep
+// coming
+const watchMaxDelay = 10
+
+// Directories to update, collected from watch events during debounce
+type watchBatch struct {
+	dirs    map[string]bool // directories which entries changed
+	rescans map[string]bool // subtrees to walk (and watch) as a whole
+}
+
+func newWatchBatch() *watchBatch {
+	var ret = new(watchBatch)
+	ret.dirs = make(map[string]bool)
+	ret.rescans = make(map[string]bool)
+	return ret
+}
+
+func (batch *watchBatch) empty() bool {
+	return len(batch.dirs) == 0 && len(batch.rescans) == 0
+}
+
+func (batch *watchBatch) add(root string, event watchEvent) {
+	if event.overflow {
+		batch.rescans[root] = true
+		return
+	}
+	if isTempFile(event.name) {
+		return
+	}
+	if event.isDir {
+		// New or moved in directory has to be watched and walked as a
+		// whole, for removed one this is no-op
+		batch.rescans[filepath.Join(event.directory, event.name)] = true
+	} else if event.name == ignoreFileName || event.name == dirConfigFileName {
+		batch.rescans[event.directory] = true
+	}
+	batch.dirs[event.directory] = true
+}
+
+// Dig limits of Watch call
+type watchLimits struct {
+	minDig int
+	maxDig int
+}
+
+func (tally *tally) Watch(ctx context.Context, directory string, minDig, maxDig int) error {
+	var watcher, err = newDirWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.close()
+
+	var root = filepath.Clean(directory)
+	var limits = watchLimits{minDig, maxDig}
+	var debounce = tally.config.WatchDebounce
+	var batch = newWatchBatch()
+	// The first pass updates (and watches) the whole tree
+	batch.rescans[root] = true
+	var deadline = time.After(0)
+	var first time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.events():
+			if !ok {
+				return errors.New("Watcher stopped unexpectedly")
+			}
+			batch.add(root, event)
+			var now = time.Now()
+			if first.IsZero() {
+				first = now
+			}
+			var delay = debounce
+			var latest = first.Add(debounce * watchMaxDelay)
+			if now.Add(delay).After(latest) {
+				delay = latest.Sub(now)
+			}
+			deadline = time.After(delay)
+		case <-deadline:
+			deadline = nil
+			first = time.Time{}
+			if !batch.empty() {
+				err = tally.updateWatched(ctx, watcher, root, limits, batch)
+				if err != nil && ctx.Err() == nil {
+					tally.err("Cannot update", root, err)
+				}
+				batch = newWatchBatch()
+			}
+		}
+	}
+}
+
+// Updates collections of changed directories, deepest first, followed by
+// collections of their parents
+func (tally *tally) updateWatched(
+	ctx context.Context,
+	watcher dirWatcher,
+	root string,
+	limits watchLimits,
+	batch *watchBatch) error {
+
+	defer tally.end()
+	var err = tally.begin(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range byDepth(batch.rescans) {
+		if !isDirectory(dir) {
+			continue
+		}
+		tally.info("Rescanning", dir)
+		tally.watchTree(watcher, dir)
+		var target watchTarget
+		target, err = tally.watchTargetFor(root, dir, limits)
+		if err != nil {
+			return err
+		}
+		var changed bool
+		changed, err = tally.updateChildren(target.directory, target.minDig, target.maxDig, target.depth)
+		if err != nil {
+			return err
+		}
+		if changed {
+			err = tally.propagateChange(root, target.directory, batch)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Parents are added to batch.dirs as their children change, so
+	// directories are taken one depth level at a time
+	for len(batch.dirs) > 0 {
+		for _, dir := range deepest(batch.dirs) {
+			delete(batch.dirs, dir)
+			if !isDirectory(dir) {
+				continue
+			}
+			var target watchTarget
+			target, err = tally.watchTargetFor(root, dir, limits)
+			if err != nil {
+				return err
+			}
+			if target.depth < target.minDig {
+				tally.debug("No collection for", dir, "above MinDig")
+				continue
+			}
+			var changed bool
+			changed, err = tally.updateSingleDirectory(target.directory, target.collapsed())
+			if err != nil {
+				return err
+			}
+			if changed {
+				err = tally.propagateChange(root, target.directory, batch)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Directory which collection has to be updated when a directory changes,
+// with dig limits and depth updateChildren would use for it
+type watchTarget struct {
+	directory string
+	minDig    int
+	maxDig    int
+	depth     int
+}
+
+// Returns true if subdirectories go into collection of the target itself
+func (target watchTarget) collapsed() bool {
+	return target.maxDig >= 0 && target.depth >= target.maxDig
+}
+
+// Walks from root down to dir the way updateChildren does, applying
+// .tally.conf dig limits on the way. Stops at the first directory at or
+// beyond maxDig, since collection of that one includes dir
+func (tally *tally) watchTargetFor(root, dir string, limits watchLimits) (watchTarget, error) {
+	var target = watchTarget{directory: root, minDig: limits.minDig, maxDig: limits.maxDig}
+	var path []string
+	if rel, err := filepath.Rel(root, dir); err == nil && rel != "." {
+		path = strings.Split(rel, string(filepath.Separator))
+	}
+	for i := 0; ; i++ {
+		var settings, err = tally.settingsFor(target.directory)
+		if err != nil {
+			return target, err
+		}
+		if own, ownMax, found := settings.own.digLimits(); found {
+			target.minDig, target.maxDig, target.depth = own, ownMax, 0
+		}
+		if i == len(path) || target.collapsed() {
+			return target, nil
+		}
+		target.directory = filepath.Join(target.directory, path[i])
+		target.depth++
+	}
+}
+
+// Collection of dir changed, so does collection of its parent
+func (tally *tally) propagateChange(root, dir string, batch *watchBatch) error {
+	if dir != root {
+		batch.dirs[filepath.Dir(dir)] = true
+		return nil
+	}
+	if tally.config.UpdateParents {
+		var _, err = tally.updateParents(root)
+		return err
+	}
+	return nil
+}
+
+// Watches directory and all its subdirectories except excluded ones.
+// Directories which cannot be watched are skipped with a warning
+func (tally *tally) watchTree(watcher dirWatcher, directory string) {
+	var err = watcher.add(directory)
+	if err != nil {
+		tally.warn("Cannot watch", directory, err)
+		return
+	}
+	var files []os.FileInfo
+	files, err = tally.listDirectory(directory)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if !tally.isDir(file) {
+			continue
+		}
+		var fullpath = filepath.Join(directory, file.Name())
+		if tally.enterDirectory(fullpath, file) {
+			tally.watchTree(watcher, fullpath)
+			tally.leaveDirectory()
+		}
+	}
+}
+
+// Returns directories sorted deepest first
+func byDepth(dirs map[string]bool) []string {
+	var ret = make([]string, 0, len(dirs))
+	for dir := range dirs {
+		ret = append(ret, dir)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if depth(ret[i]) != depth(ret[j]) {
+			return depth(ret[i]) > depth(ret[j])
+		}
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+// Returns sorted directories which are deeper than any other
+func deepest(dirs map[string]bool) []string {
+	var ret []string
+	var max = -1
+	for dir := range dirs {
+		var d = depth(dir)
+		if d > max {
+			max = d
+			ret = ret[:0]
+		}
+		if d == max {
+			ret = append(ret, dir)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func depth(dir string) int {
+	return strings.Count(dir, string(filepath.Separator))
+}
+
+func isDirectory(path string) bool {
+	var stat, err = os.Stat(path)
+	return err == nil && stat.IsDir()
+}
@@ -0,0 +1,90 @@
+package tallylib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_watchBatch_add(t *testing.T) {
+	var batch = newWatchBatch()
+	batch.add("/root", watchEvent{directory: "/root/a", name: "file"})
+	batch.add("/root", watchEvent{directory: "/root/a", name: ".a.rscollection" + tempFileSuffix})
+	batch.add("/root", watchEvent{directory: "/root/a", name: "new", isDir: true})
+	batch.add("/root", watchEvent{directory: "/root/b", name: ignoreFileName})
+	assertIntEquals(t, "dirs", 2, len(batch.dirs))
+	assertIntEquals(t, "rescans", 2, len(batch.rescans))
+	if !batch.rescans["/root/a/new"] || !batch.rescans["/root/b"] {
+		t.Error("unexpected rescans", batch.rescans)
+	}
+
+	batch.add("/root", watchEvent{overflow: true})
+	if !batch.rescans["/root"] {
+		t.Error("overflow must rescan the whole tree")
+	}
+}
+
+func Test_deepest(t *testing.T) {
+	var dirs = map[string]bool{"/a": true, "/a/b/c": true, "/a/b": true, "/a/d/e": true}
+	var got = deepest(dirs)
+	assertIntEquals(t, "deepest", 2, len(got))
+	assertStringEquals(t, "/a/b/c", got[0])
+	assertStringEquals(t, "/a/d/e", got[1])
+	assertStringEquals(t, "/a/b/c", byDepth(dirs)[0])
+	assertStringEquals(t, "/a", byDepth(dirs)[3])
+}
+
+func Test_watchTargetFor_will_follow_dig_limits(t *testing.T) {
+	tmpdir := mktmp("Test_watchTargetFor_will_follow_dig_limits")
+	defer os.RemoveAll(tmpdir)
+	root := mkdir(tmpdir, "root")
+	a := mkdir(root, "a")
+	c := mkdir(mkdir(a, "b"), "c")
+	d := mkdir(c, "d")
+	other := mkdir(root, "other")
+	deep := mkdir(mkdir(mkdir(other, "x"), "y"), "z")
+	writefile(c, dirConfigFileName, `{"MaxDig": 0}`)
+
+	var fixture = createFixture().(*tally)
+	fixture.begin(context.Background(), root)
+	defer fixture.end()
+	var limits = watchLimits{minDig: 1, maxDig: 2}
+
+	var target, err = fixture.watchTargetFor(root, root, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEquals(t, root, target.directory)
+	if target.depth >= target.minDig {
+		t.Error("root is above MinDig")
+	}
+
+	target, err = fixture.watchTargetFor(root, deep, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEquals(t, filepath.Join(other, "x"), target.directory)
+	if !target.collapsed() {
+		t.Error("directory at MaxDig holds its whole subtree")
+	}
+
+	target, err = fixture.watchTargetFor(root, a, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEquals(t, a, target.directory)
+	if target.collapsed() || target.depth != 1 {
+		t.Error("directory above MaxDig has its own collection", target)
+	}
+
+	// .tally.conf dig limits count from its directory
+	target, err = fixture.watchTargetFor(root, d, watchLimits{minDig: 0, maxDig: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEquals(t, c, target.directory)
+	if !target.collapsed() {
+		t.Error(".tally.conf MaxDig is not applied", target)
+	}
+}
@@ -0,0 +1,93 @@
+package tallylib
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// dirWatcher based on Linux inotify
+type inotifyWatcher struct {
+	fd   int
+	file *os.File // same fd, non-blocking, so that Close interrupts Read
+	lock sync.Mutex
+	dirs map[int32]string // watched directories by watch descriptor
+	out  chan watchEvent
+}
+
+func newDirWatcher() (dirWatcher, error) {
+	var fd, err = syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	var ret = new(inotifyWatcher)
+	ret.fd = fd
+	ret.file = os.NewFile(uintptr(fd), "inotify")
+	ret.dirs = make(map[int32]string)
+	ret.out = make(chan watchEvent, 256)
+	go ret.read()
+	return ret, nil
+}
+
+func (w *inotifyWatcher) add(directory string) error {
+	var wd, err = syscall.InotifyAddWatch(w.fd, directory, inotifyMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	// Watching the same directory (e.g. after it was moved) returns
+	// the same descriptor, so the path just gets updated
+	w.dirs[int32(wd)] = directory
+	return nil
+}
+
+func (w *inotifyWatcher) events() <-chan watchEvent {
+	return w.out
+}
+
+func (w *inotifyWatcher) close() error {
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) read() {
+	defer close(w.out)
+	var buf [256 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	for {
+		var n, err = w.file.Read(buf[:])
+		if err != nil {
+			return
+		}
+		var offset = 0
+		for offset+syscall.SizeofInotifyEvent <= n {
+			var raw = (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			var start = offset + syscall.SizeofInotifyEvent
+			offset = start + int(raw.Len)
+			var name = strings.TrimRight(string(buf[start:offset]), "\x00")
+			w.dispatch(raw.Wd, raw.Mask, name)
+		}
+	}
+}
+
+func (w *inotifyWatcher) dispatch(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.out <- watchEvent{overflow: true}
+		return
+	}
+	w.lock.Lock()
+	var directory, found = w.dirs[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		// Directory is gone, its parent gets an event of its own
+		delete(w.dirs, wd)
+		found = false
+	}
+	w.lock.Unlock()
+	if found {
+		w.out <- watchEvent{directory: directory, name: name, isDir: mask&syscall.IN_ISDIR != 0}
+	}
+}
@@ -0,0 +1,116 @@
+package tallylib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Watch_will_update_changed_directories_and_parents(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.WatchDebounce = 20 * time.Millisecond
+	config.UpdateParents = true
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_Watch_will_update_changed_directories_and_parents")
+	defer os.RemoveAll(tmpdir)
+	subdir1 := mkdir(tmpdir, "subdir1")
+	writefile(subdir1, "file1", "Hello, world!")
+	subdir2 := mkdir(subdir1, "subdir2")
+	writefile(subdir2, "file2", "Hello 2")
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	var done = make(chan error)
+	go func() {
+		done <- fixture.Watch(ctx, subdir1, 0, -1)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Error("Watch should stop with context.Canceled, got", err)
+		}
+	}()
+
+	waitForCollection(t, subdir2, "file2")
+	var collection2 = resolveCollectionFileSimple(subdir2)
+	var oldSha = readShaFor(collection2)
+
+	writefile(subdir2, "file3", "Hello 3")
+	waitForCollection(t, subdir2, "file3")
+	waitFor(t, "parent collection updated", func() bool {
+		var coll = tryLoadCollection(resolveCollectionFileSimple(subdir1))
+		var record RSCollectionFile
+		if coll != nil {
+			record = coll.ByName(filepath.Base(collection2))
+		}
+		return record != nil && record.Sha1() != oldSha
+	})
+
+	subdir3 := mkdir(subdir2, "subdir3")
+	writefile(subdir3, "file4", "Hello 4")
+	waitForCollection(t, subdir3, "file4")
+	waitForCollection(t, subdir2, "subdir3.rscollection")
+}
+
+func Test_Watch_will_honor_MaxDig(t *testing.T) {
+	fixture := createFixture()
+	var config = fixture.GetConfig()
+	config.WatchDebounce = 20 * time.Millisecond
+	fixture.SetConfig(config)
+	tmpdir := mktmp("Test_Watch_will_honor_MaxDig")
+	defer os.RemoveAll(tmpdir)
+	music := mkdir(tmpdir, "music")
+	writefile(music, "file1", "Hello, world!")
+	album := mkdir(mkdir(music, "Artist"), "Album")
+	writefile(album, "track1", "Hello 2")
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	var done = make(chan error)
+	go func() {
+		done <- fixture.Watch(ctx, music, 0, 0)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Error("Watch should stop with context.Canceled, got", err)
+		}
+	}()
+
+	waitForCollection(t, music, "Artist/Album/track1")
+	writefile(album, "track2", "Hello 3")
+	waitForCollection(t, music, "Artist/Album/track2")
+	assertPathNotExists(t, resolveCollectionFileSimple(album))
+	assertPathNotExists(t, filepath.Join(music, "Artist.rscollection"))
+}
+
+func waitForCollection(t *testing.T, directory, name string) {
+	waitFor(t, name+" in collection of "+directory, func() bool {
+		var coll = tryLoadCollection(resolveCollectionFileSimple(directory))
+		return coll != nil && coll.ByName(name) != nil
+	})
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	var deadline = time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func tryLoadCollection(path string) RSCollection {
+	var file, err = os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var coll = NewCollection()
+	if coll.LoadFrom(file) != nil {
+		return nil
+	}
+	return coll
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package tallylib
+
+import "errors"
+
+func newDirWatcher() (dirWatcher, error) {
+	return nil, errors.New("watching directories is only supported on Linux")
+}